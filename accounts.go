@@ -0,0 +1,345 @@
+package opaitokens
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultAccountEnvPrefix 环境变量账号的默认前缀, 如 OPAI_ACCOUNT_1_EMAIL
+const DefaultAccountEnvPrefix = "OPAI_ACCOUNT_"
+
+var accountEmailPattern = regexp.MustCompile(`^[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}$`)
+
+// AccountLoadError 账号文件中某一行的错误
+type AccountLoadError struct {
+	Line int
+	Err  error
+}
+
+func (e *AccountLoadError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *AccountLoadError) Unwrap() error {
+	return e.Err
+}
+
+// AccountLoadErrors 加载账号时的所有错误
+type AccountLoadErrors []*AccountLoadError
+
+func (e AccountLoadErrors) Error() string {
+	var lines []string
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	return "load accounts failed: " + strings.Join(lines, "; ")
+}
+
+// accountCollector 校验并按email去重
+type accountCollector struct {
+	accounts []OpenaiAccount
+	seen     map[string]int
+	errs     AccountLoadErrors
+}
+
+func newAccountCollector() *accountCollector {
+	return &accountCollector{seen: make(map[string]int)}
+}
+
+func (c *accountCollector) fail(line int, err error) {
+	c.errs = append(c.errs, &AccountLoadError{Line: line, Err: err})
+}
+
+func (c *accountCollector) add(line int, account OpenaiAccount) {
+	account.Email = strings.TrimSpace(account.Email)
+	if err := ValidateAccount(account); err != nil {
+		c.fail(line, err)
+		return
+	}
+	key := strings.ToLower(account.Email)
+	if first, ok := c.seen[key]; ok {
+		c.fail(line, fmt.Errorf("duplicate email %s, first defined at line %d", account.Email, first))
+		return
+	}
+	c.seen[key] = line
+	c.accounts = append(c.accounts, account)
+}
+
+func (c *accountCollector) result() ([]OpenaiAccount, error) {
+	if len(c.errs) > 0 {
+		return c.accounts, c.errs
+	}
+	return c.accounts, nil
+}
+
+// ValidateAccount
+//
+//	@Description: 校验账号email格式, 至少包含一种凭证, 代理地址合法
+//	@param account
+//	@return error
+func ValidateAccount(account OpenaiAccount) error {
+	if !accountEmailPattern.MatchString(account.Email) {
+		return fmt.Errorf("invalid email: %q", account.Email)
+	}
+	if account.Password == "" && account.RefreshToken == "" && account.SessionToken == "" {
+		return errors.New("password, refresh token or session token is required for " + account.Email)
+	}
+	if account.ShareTokenOptions != nil {
+		if err := account.ShareTokenOptions.Validate(); err != nil {
+			return err
+		}
+	}
+	if account.Proxy != "" {
+		u, err := url.Parse(account.Proxy)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid proxy: %q", account.Proxy)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("unsupported proxy scheme: %q", u.Scheme)
+		}
+	}
+	return nil
+}
+
+// LoadAccountsCSV
+//
+//	@Description: 读取csv账号, 第一行为表头
+//	支持的列: email,password,mfa,refresh_token,session_token,proxy,tags (tags使用;分隔)
+//	@param r
+//	@return []OpenaiAccount
+//	@return error
+func LoadAccountsCSV(r io.Reader) ([]OpenaiAccount, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("read csv header failed: " + err.Error())
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, errors.New("csv header must contain email column")
+	}
+
+	collector := newAccountCollector()
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				collector.fail(parseErr.StartLine, parseErr.Err)
+				continue
+			}
+			return collector.accounts, err
+		}
+		line, _ := reader.FieldPos(0)
+		get := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		collector.add(line, OpenaiAccount{
+			Email:        get("email"),
+			Password:     get("password"),
+			MFA:          get("mfa"),
+			RefreshToken: get("refresh_token"),
+			SessionToken: get("session_token"),
+			Proxy:        get("proxy"),
+			Tags:         splitTags(get("tags"), ";"),
+		})
+	}
+	return collector.result()
+}
+
+// LoadAccountsJSON
+//
+//	@Description: 读取json数组格式的账号
+//	@param r
+//	@return []OpenaiAccount
+//	@return error
+func LoadAccountsJSON(r io.Reader) ([]OpenaiAccount, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.New("read json failed: " + err.Error())
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	lineAt := func(offset int64) int {
+		return bytes.Count(data[:offset], []byte("\n")) + 1
+	}
+	token, err := decoder.Token()
+	if err != nil || token != json.Delim('[') {
+		return nil, errors.New("json accounts must be an array")
+	}
+	collector := newAccountCollector()
+	for decoder.More() {
+		offset := decoder.InputOffset()
+		var account OpenaiAccount
+		if err := decoder.Decode(&account); err != nil {
+			collector.fail(lineAt(offset), err)
+			return collector.result()
+		}
+		// 跳过数组分隔符和空白, 定位到元素起始行
+		start := offset
+		for start < int64(len(data)) && strings.ContainsRune(", \t\r\n", rune(data[start])) {
+			start++
+		}
+		collector.add(lineAt(start), account)
+	}
+	return collector.result()
+}
+
+// LoadAccountsJSONL
+//
+//	@Description: 读取jsonl格式的账号, 每行一个json对象, 空行和#开头的行会被忽略
+//	@param r
+//	@return []OpenaiAccount
+//	@return error
+func LoadAccountsJSONL(r io.Reader) ([]OpenaiAccount, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	collector := newAccountCollector()
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var account OpenaiAccount
+		if err := json.Unmarshal([]byte(text), &account); err != nil {
+			collector.fail(line, err)
+			continue
+		}
+		collector.add(line, account)
+	}
+	if err := scanner.Err(); err != nil {
+		return collector.accounts, errors.New("read jsonl failed: " + err.Error())
+	}
+	return collector.result()
+}
+
+// LoadAccountsFromEnv
+//
+//	@Description: 从环境变量读取账号, 格式为 <prefix><N>_<FIELD>
+//	如 OPAI_ACCOUNT_1_EMAIL, OPAI_ACCOUNT_1_PASSWORD, OPAI_ACCOUNT_1_TAGS(逗号分隔)
+//	错误中的行号为账号序号N
+//	@param prefix 为空时使用DefaultAccountEnvPrefix
+//	@return []OpenaiAccount
+//	@return error
+func LoadAccountsFromEnv(prefix string) ([]OpenaiAccount, error) {
+	if prefix == "" {
+		prefix = DefaultAccountEnvPrefix
+	}
+	fields := make(map[int]map[string]string)
+	for _, env := range os.Environ() {
+		pair := strings.SplitN(env, "=", 2)
+		if len(pair) != 2 || !strings.HasPrefix(pair[0], prefix) {
+			continue
+		}
+		rest := strings.SplitN(strings.TrimPrefix(pair[0], prefix), "_", 2)
+		if len(rest) != 2 {
+			continue
+		}
+		index, err := strconv.Atoi(rest[0])
+		if err != nil {
+			continue
+		}
+		if fields[index] == nil {
+			fields[index] = make(map[string]string)
+		}
+		fields[index][strings.ToUpper(rest[1])] = pair[1]
+	}
+	var indexes []int
+	for index := range fields {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	collector := newAccountCollector()
+	for _, index := range indexes {
+		f := fields[index]
+		collector.add(index, OpenaiAccount{
+			Email:        f["EMAIL"],
+			Password:     f["PASSWORD"],
+			MFA:          f["MFA"],
+			RefreshToken: f["REFRESH_TOKEN"],
+			SessionToken: f["SESSION_TOKEN"],
+			Proxy:        f["PROXY"],
+			Tags:         splitTags(f["TAGS"], ","),
+		})
+	}
+	return collector.result()
+}
+
+// LoadAccountsFile
+//
+//	@Description: 根据文件扩展名(.csv .json .jsonl)读取账号
+//	@param path
+//	@return []OpenaiAccount
+//	@return error
+func LoadAccountsFile(path string) ([]OpenaiAccount, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.New("open accounts file failed: " + err.Error())
+	}
+	defer file.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return LoadAccountsCSV(file)
+	case ".json":
+		return LoadAccountsJSON(file)
+	case ".jsonl", ".ndjson":
+		return LoadAccountsJSONL(file)
+	}
+	return nil, errors.New("unsupported accounts file: " + path)
+}
+
+// RefreshTokenAccounts
+//
+//	@Description: 取出带有refresh token的账号, 用于refresh token相关的批量接口
+//	@param accounts
+//	@return []RenewSharedTokenRFT
+func RefreshTokenAccounts(accounts []OpenaiAccount) []RenewSharedTokenRFT {
+	var result []RenewSharedTokenRFT
+	for _, account := range accounts {
+		if account.RefreshToken != "" {
+			result = append(result, RenewSharedTokenRFT{
+				OpenaiAccountEmail: account.Email,
+				OpenaiRefreshToken: account.RefreshToken,
+			})
+		}
+	}
+	return result
+}
+
+func splitTags(value string, sep string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, sep) {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
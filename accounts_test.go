@@ -0,0 +1,77 @@
+package opaitokens
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLoadAccountsCSV(t *testing.T) {
+	data := "email,password,mfa,refresh_token,proxy,tags\n" +
+		"a@example.com,pass,,,http://127.0.0.1:7890,core;team\n" +
+		"not-an-email,pass,,,,\n" +
+		"A@example.com,pass2,,,,\n" +
+		"b@example.com,,,rt-xxxx,,\n"
+	accounts, err := LoadAccountsCSV(strings.NewReader(data))
+	if len(accounts) != 2 {
+		t.Fatalf("expected 2 accounts, got %v", accounts)
+	}
+	if len(accounts[0].Tags) != 2 || accounts[0].Proxy != "http://127.0.0.1:7890" {
+		t.Errorf("unexpected account: %+v", accounts[0])
+	}
+	var loadErrs AccountLoadErrors
+	if !errors.As(err, &loadErrs) || len(loadErrs) != 2 {
+		t.Fatalf("expected 2 load errors, got %v", err)
+	}
+	if loadErrs[0].Line != 3 || loadErrs[1].Line != 4 {
+		t.Errorf("unexpected error lines: %v", err)
+	}
+	if rfts := RefreshTokenAccounts(accounts); len(rfts) != 1 || rfts[0].OpenaiRefreshToken != "rt-xxxx" {
+		t.Errorf("unexpected refresh token accounts: %v", rfts)
+	}
+}
+
+func TestLoadAccountsJSON(t *testing.T) {
+	data := `[
+  {"email": "a@example.com", "password": "pass"},
+  {"email": "b@example.com"}
+]`
+	accounts, err := LoadAccountsJSON(strings.NewReader(data))
+	if len(accounts) != 1 {
+		t.Fatalf("expected 1 account, got %v", accounts)
+	}
+	var loadErrs AccountLoadErrors
+	if !errors.As(err, &loadErrs) || loadErrs[0].Line != 3 {
+		t.Errorf("expected error at line 3, got %v", err)
+	}
+}
+
+func TestLoadAccountsJSONL(t *testing.T) {
+	data := "# accounts\n" +
+		`{"email": "a@example.com", "password": "pass", "tags": ["intern"]}` + "\n" +
+		"\n" +
+		`{"email": "b@example.com", "session_token": "xxx"` + "\n"
+	accounts, err := LoadAccountsJSONL(strings.NewReader(data))
+	if len(accounts) != 1 || accounts[0].Tags[0] != "intern" {
+		t.Fatalf("unexpected accounts: %v", accounts)
+	}
+	var loadErrs AccountLoadErrors
+	if !errors.As(err, &loadErrs) || loadErrs[0].Line != 4 {
+		t.Errorf("expected error at line 4, got %v", err)
+	}
+}
+
+func TestLoadAccountsFromEnv(t *testing.T) {
+	t.Setenv("TEST_ACCOUNT_2_EMAIL", "b@example.com")
+	t.Setenv("TEST_ACCOUNT_2_REFRESH_TOKEN", "rt-xxxx")
+	t.Setenv("TEST_ACCOUNT_1_EMAIL", "a@example.com")
+	t.Setenv("TEST_ACCOUNT_1_PASSWORD", "pass")
+	t.Setenv("TEST_ACCOUNT_1_TAGS", "core, team")
+	accounts, err := LoadAccountsFromEnv("TEST_ACCOUNT_")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(accounts) != 2 || accounts[0].Email != "a@example.com" || len(accounts[0].Tags) != 2 {
+		t.Errorf("unexpected accounts: %v", accounts)
+	}
+}
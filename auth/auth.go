@@ -1,11 +1,10 @@
 package auth
 
 import (
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/fireinrain/opaitokens/utils"
+	"github.com/fireinrain/opaitokens/internal/transport"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
@@ -27,15 +26,64 @@ type Auth0 struct {
 	reqHeaders   http.Header
 	accessToken  string
 	refreshToken string
+	idToken      string
 	expires      time.Time
 	userAgent    string
 	authForCode  bool
+	authorize    AuthorizeConfig
+	//fakeopen 网关地址, 为空时使用DefaultApiPrefix
+	apiPrefixes []string
+	//最近一次登录使用的授权请求
+	authorizeRequest *AuthorizeRequest
+}
+
+const defaultUserAgent = transport.DefaultUserAgent
+
+// Config Auth0 的可选配置
+type Config struct {
+	//登录使用的client 为空时使用默认client, 登录过程会修改重定向策略, 因此使用的是其副本
+	Client *http.Client
+	//为空时使用默认的User-Agent
+	UserAgent string
+	//缓存未过期的access token
+	UseCache bool
+	//授权地址参数 为空时使用openai ios 客户端的参数
+	Authorize AuthorizeConfig
+	//preauth 和代理登录使用的fakeopen 地址 为空时使用按日期生成的默认地址
+	BaseURL string
+	//BaseURL 不可用时依次尝试的地址
+	Mirrors []string
 }
 
 func NewAuth0(email, password string, mfa string, useCache bool) *Auth0 {
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		Proxy:           http.ProxyFromEnvironment,
+	return NewAuth0WithConfig(email, password, mfa, Config{UseCache: useCache})
+}
+
+// NewAuth0WithConfig
+//
+//	@Description: 使用配置创建Auth0
+//	@param email
+//	@param password
+//	@param mfa
+//	@param cfg
+//	@return *Auth0
+func NewAuth0WithConfig(email, password string, mfa string, cfg Config) *Auth0 {
+	var client http.Client
+	if cfg.Client != nil {
+		client = *cfg.Client
+	} else {
+		//复用默认连接池, 使用auth 自己的cookie
+		client = *transport.Default()
+		client.Jar = nil
+	}
+	if client.Jar == nil {
+		client.Jar = jar
+	}
+	//登录过程会修改重定向策略, 使用副本
+	session := &client
+	userAgent := cfg.UserAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent
 	}
 
 	auth := &Auth0{
@@ -43,19 +91,19 @@ func NewAuth0(email, password string, mfa string, useCache bool) *Auth0 {
 		email:        email,
 		password:     password,
 		mfa:          mfa,
-		useCache:     useCache,
-		session: &http.Client{
-			Timeout:   time.Second * 100,
-			Transport: tr,
-			Jar:       jar,
-		},
+		useCache:     cfg.UseCache,
+		session:      session,
 		reqHeaders: http.Header{
-			"User-Agent": []string{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/109.0.0.0 Safari/537.36"},
+			"User-Agent": []string{userAgent},
 		},
 		accessToken: "",
 		expires:     time.Time{},
-		userAgent:   "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/109.0.0.0 Safari/537.36",
+		userAgent:   userAgent,
 		authForCode: false,
+		authorize:   cfg.Authorize.withDefaults(),
+	}
+	if cfg.BaseURL != "" {
+		auth.apiPrefixes = append([]string{cfg.BaseURL}, cfg.Mirrors...)
 	}
 	return auth
 }
@@ -90,12 +138,33 @@ func (a *Auth0) GetRefreshToken() string {
 	return a.refreshToken
 }
 
+// GetIDToken
+//
+//	@Description: 返回idToken
+//	@receiver a
+//	@return string
+func (a *Auth0) GetIDToken() string {
+	return a.idToken
+}
+
+// AuthorizeRequest
+//
+//	@Description: 返回最近一次登录使用的授权请求, 用于在外部换取token后校验id token 的nonce
+//	@receiver a
+//	@return *AuthorizeRequest
+func (a *Auth0) AuthorizeRequest() *AuthorizeRequest {
+	return a.authorizeRequest
+}
+
 // DefaultApiPrefix
 //
-//	@Description: 获取默认的fakeopen网关地址
+//	@Description: 获取默认的fakeopen网关地址, 配置了Config.BaseURL 时返回该地址
 //	@receiver a
 //	@return string
 func (a *Auth0) DefaultApiPrefix() string {
+	if len(a.apiPrefixes) > 0 {
+		return strings.TrimRight(a.apiPrefixes[0], "/")
+	}
 	// Get the current date and subtract one day
 	yesterday := time.Now().Add(-24 * time.Hour)
 
@@ -107,6 +176,45 @@ func (a *Auth0) DefaultApiPrefix() string {
 	return url
 }
 
+// doApi
+//
+//	@Description: 依次向fakeopen 网关地址发送请求, 网络错误或5xx 时尝试下一个地址
+//	@receiver a
+//	@param method
+//	@param path
+//	@param body
+//	@param headers
+//	@return *http.Response
+//	@return error
+func (a *Auth0) doApi(method, path string, body string, headers http.Header) (*http.Response, error) {
+	prefixes := a.apiPrefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{a.DefaultApiPrefix()}
+	}
+	var lastErr error
+	for index, prefix := range prefixes {
+		req, err := http.NewRequest(method, strings.TrimRight(prefix, "/")+path, strings.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("error creating request: %v", err)
+		}
+		if headers != nil {
+			req.Header = headers.Clone()
+		}
+		resp, err := a.session.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 500 && index < len(prefixes)-1 {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("%s: %s", prefix, resp.Status)
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
 // AuthForCodeUrl
 //
 //	@Description: 获取回调code 和回调url
@@ -135,16 +243,11 @@ func (a *Auth0) AuthForCodeUrl() (string, error) {
 //	@return string
 //	@return error
 func (a *Auth0) partOne() (string, error) {
-	preauthUrl := fmt.Sprintf("%s/auth/preauth", a.DefaultApiPrefix())
-	req, err := http.NewRequest("GET", preauthUrl, nil)
-	if err != nil {
-		return "", fmt.Errorf("error creating request: %v", err)
-	}
 	a.session.CheckRedirect = func(req *http.Request, via []*http.Request) error {
 		return http.ErrUseLastResponse
 	}
 
-	resp, err := a.session.Do(req)
+	resp, err := a.doApi("GET", "/auth/preauth", "", nil)
 	if err != nil {
 		return "", fmt.Errorf("error fetch preauth code in: %v", err)
 	}
@@ -166,20 +269,13 @@ func (a *Auth0) partOne() (string, error) {
 }
 
 func (a *Auth0) partTwo(preauth string) (string, error) {
-	codeVerifier := utils.GenerateCodeVerifier()
-	codeChallenge := utils.GenerateCodeChallenge(codeVerifier)
-	//codeChallenge := "w6n3Ix420Xhhu-Q5-mOOEyuPZmAsJHUbBpO8Ub7xBCY"
-	//codeVerifier := "yGrXROHx_VazA0uovsxKfE263LMFcrSrdm4SlC-rob8"
-	encodedString := "https://auth0.openai.com/authorize?client_id=pdlLIX2Y72MIl2rhLhTE9VV9bN905kBh&" +
-		"audience=https%3A%2F%2Fapi.openai.com%2Fv1&redirect_uri=com.openai.chat%3A%2F%2Fauth0.openai.com%2Fios%2Fcom.openai.chat%2Fcallback&" +
-		"scope=openid%20email%20profile%20offline_access%20model.request%20model.read%20organization.read%20offline&response_type=code&" +
-		"code_challenge=w6n3Ix420Xhhu-Q5-mOOEyuPZmAsJHUbBpO8Ub7xBCY&code_challenge_method=S256&prompt=login&preauth_cookie=sample_preauth_cookie"
-	//fmt.Println("decoded string:", decodedString)
-	re := regexp.MustCompile(`code_challenge=[^&]+`)
-	replacement := "code_challenge=" + codeChallenge
-	newUrl := re.ReplaceAllString(encodedString, replacement)
-	newUrl = strings.ReplaceAll(newUrl, "sample_preauth_cookie", preauth)
-	return a.partThree(codeVerifier, newUrl)
+	req, err := NewAuthorizeRequest(a.authorize)
+	if err != nil {
+		return "", fmt.Errorf("error creating authorize request: %v", err)
+	}
+	req.PreauthCookie = preauth
+	a.authorizeRequest = req
+	return a.partThree(req.CodeVerifier, req.URL())
 }
 
 func (a *Auth0) partThree(codeVerifier, urlStr string) (string, error) {
@@ -397,32 +493,24 @@ func (a *Auth0) partSeven(codeVerifier string, location string) (string, error)
 }
 
 func (a *Auth0) getAccessToken(codeVerifier, callbackURL string) (string, error) {
-
-	u, err := url.Parse(callbackURL)
-	if err != nil {
-		return "", fmt.Errorf("error parsing callback url: %v", err)
+	if a.authorizeRequest == nil {
+		return "", errors.New("authorize request not found")
 	}
-
-	urlParams := u.Query()
-	if errorParam := urlParams.Get("error"); errorParam != "" {
-		errorDesc := urlParams.Get("error_description")
-		return "", fmt.Errorf("%s: %s", errorParam, errorDesc)
-	}
-
-	code := urlParams.Get("code")
-	if code == "" {
-		return "", fmt.Errorf("error getting code from callback url: %v", callbackURL)
+	//校验state 并获取code
+	code, err := a.authorizeRequest.ParseCallback(callbackURL)
+	if err != nil {
+		return "", err
 	}
 	//判断是否返回codeVerifier 和 code
 	if a.authForCode {
 		return codeVerifier + "|" + code, nil
 	}
 
-	urlStr := "https://auth0.openai.com/oauth/token"
+	urlStr := a.authorize.TokenURL
 	data := url.Values{
-		"redirect_uri":  {"com.openai.chat://auth0.openai.com/ios/com.openai.chat/callback"},
+		"redirect_uri":  {a.authorize.RedirectURI},
 		"grant_type":    {"authorization_code"},
-		"client_id":     {"pdlLIX2Y72MIl2rhLhTE9VV9bN905kBh"},
+		"client_id":     {a.authorize.ClientID},
 		"code":          {code},
 		"code_verifier": {codeVerifier},
 	}
@@ -451,14 +539,21 @@ func (a *Auth0) getAccessToken(codeVerifier, callbackURL string) (string, error)
 			AccessToken  string `json:"access_token"`
 			ExpiresIn    int    `json:"expires_in"`
 			RefreshToken string `json:"refresh_token"`
+			IDToken      string `json:"id_token"`
 		}
 		err := json.NewDecoder(resp.Body).Decode(&response)
 		if err != nil {
 			return "", fmt.Errorf("error decoding response: %v", err)
 		}
+		if response.IDToken != "" {
+			if err := a.authorizeRequest.VerifyIDToken(response.IDToken); err != nil {
+				return "", err
+			}
+		}
 
 		a.accessToken = response.AccessToken
 		a.refreshToken = response.RefreshToken
+		a.idToken = response.IDToken
 		expiresAt := time.Now().UTC().Add(time.Second * time.Duration(response.ExpiresIn)).Add(-5 * time.Minute)
 		a.expires = expiresAt
 		return a.accessToken, nil
@@ -469,7 +564,6 @@ func (a *Auth0) getAccessToken(codeVerifier, callbackURL string) (string, error)
 
 // TODO can't use, report 500 error
 func (a *Auth0) getAccessTokenProxy() (string, error) {
-	urlStr := fmt.Sprintf("%s/auth/login", a.DefaultApiPrefix())
 	headers := http.Header{}
 	headers.Set("User-Agent", a.userAgent)
 	headers.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -479,16 +573,11 @@ func (a *Auth0) getAccessTokenProxy() (string, error) {
 		"mfa_code": {a.mfa},
 	}
 
-	req, err := http.NewRequest("POST", urlStr, strings.NewReader(data.Encode()))
-	if err != nil {
-		return "", fmt.Errorf("error creating request: %v", err)
-	}
-	req.Header = headers
 	a.session.CheckRedirect = func(req *http.Request, via []*http.Request) error {
 		return http.ErrUseLastResponse
 	}
 
-	resp, err := a.session.Do(req)
+	resp, err := a.doApi("POST", "/auth/login", data.Encode(), headers)
 	if err != nil {
 		return "", fmt.Errorf("error getting access token: %v", err)
 	}
@@ -499,6 +588,7 @@ func (a *Auth0) getAccessTokenProxy() (string, error) {
 			AccessToken  string `json:"access_token"`
 			ExpiresIn    int    `json:"expires_in"`
 			RefreshToken string `json:"refresh_token"`
+			IDToken      string `json:"id_token"`
 		}
 		err := json.NewDecoder(resp.Body).Decode(&response)
 		if err != nil {
@@ -507,6 +597,7 @@ func (a *Auth0) getAccessTokenProxy() (string, error) {
 
 		a.accessToken = response.AccessToken
 		a.refreshToken = response.RefreshToken
+		a.idToken = response.IDToken
 		expiresAt := time.Now().UTC().Add(time.Second * time.Duration(response.ExpiresIn)).Add(-5 * time.Minute)
 		a.expires = expiresAt
 		return a.accessToken, nil
@@ -2,6 +2,8 @@ package auth
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -35,3 +37,33 @@ func TestAuthForTokenByProxy(t *testing.T) {
 	fmt.Println("Access Token:", accessToken)
 	fmt.Println("refresh Token: ", auth.refreshToken)
 }
+
+func TestAuthProxyLoginUsesBaseURL(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	var gotPath string
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		r.ParseForm()
+		if r.PostForm.Get("username") != "user@example.com" {
+			t.Errorf("unexpected form: %v", r.PostForm)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"at","refresh_token":"rt","expires_in":3600}`))
+	}))
+	defer up.Close()
+
+	auth := NewAuth0WithConfig("user@example.com", "password", "", Config{BaseURL: down.URL, Mirrors: []string{up.URL}})
+	if auth.DefaultApiPrefix() != down.URL {
+		t.Errorf("unexpected api prefix: %s", auth.DefaultApiPrefix())
+	}
+	accessToken, err := auth.Auth(false)
+	if err != nil || accessToken != "at" || auth.GetRefreshToken() != "rt" {
+		t.Fatalf("unexpected login result: %q %v", accessToken, err)
+	}
+	if gotPath != "/auth/login" {
+		t.Errorf("unexpected login path: %s", gotPath)
+	}
+}
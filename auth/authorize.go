@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/fireinrain/opaitokens/utils"
+	"net/url"
+	"strings"
+)
+
+// 默认使用openai ios 客户端的授权参数
+const (
+	DefaultAuthorizeURL = "https://auth0.openai.com/authorize"
+	DefaultTokenURL     = "https://auth0.openai.com/oauth/token"
+	DefaultClientID     = "pdlLIX2Y72MIl2rhLhTE9VV9bN905kBh"
+	DefaultAudience     = "https://api.openai.com/v1"
+	DefaultRedirectURI  = "com.openai.chat://auth0.openai.com/ios/com.openai.chat/callback"
+	DefaultScope        = "openid email profile offline_access model.request model.read organization.read offline"
+	DefaultPrompt       = "login"
+)
+
+var (
+	ErrStateMismatch = errors.New("authorize callback state mismatch")
+	ErrNonceMismatch = errors.New("id token nonce mismatch")
+)
+
+// AuthorizeConfig 授权地址的参数, 空字段使用默认值
+type AuthorizeConfig struct {
+	AuthorizeURL string
+	TokenURL     string
+	ClientID     string
+	Audience     string
+	RedirectURI  string
+	Scope        string
+	Prompt       string
+}
+
+func (c AuthorizeConfig) withDefaults() AuthorizeConfig {
+	if c.AuthorizeURL == "" {
+		c.AuthorizeURL = DefaultAuthorizeURL
+	}
+	if c.TokenURL == "" {
+		c.TokenURL = DefaultTokenURL
+	}
+	if c.ClientID == "" {
+		c.ClientID = DefaultClientID
+	}
+	if c.Audience == "" {
+		c.Audience = DefaultAudience
+	}
+	if c.RedirectURI == "" {
+		c.RedirectURI = DefaultRedirectURI
+	}
+	if c.Scope == "" {
+		c.Scope = DefaultScope
+	}
+	if c.Prompt == "" {
+		c.Prompt = DefaultPrompt
+	}
+	return c
+}
+
+// AuthorizeRequest 一次授权请求, 授权地址与随机生成的verifier, challenge, state, nonce 一一对应
+type AuthorizeRequest struct {
+	Config        AuthorizeConfig
+	CodeVerifier  string
+	CodeChallenge string
+	State         string
+	Nonce         string
+	//fakeopen preauth 返回的cookie, 为空时不添加到授权地址
+	PreauthCookie string
+}
+
+// NewAuthorizeRequest
+//
+//	@Description: 使用配置创建授权请求, 每次调用都会生成新的verifier, state 和 nonce
+//	@param cfg
+//	@return *AuthorizeRequest
+//	@return error
+func NewAuthorizeRequest(cfg AuthorizeConfig) (*AuthorizeRequest, error) {
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	verifier := utils.GenerateCodeVerifier()
+	return &AuthorizeRequest{
+		Config:        cfg.withDefaults(),
+		CodeVerifier:  verifier,
+		CodeChallenge: utils.GenerateCodeChallenge(verifier),
+		State:         state,
+		Nonce:         nonce,
+	}, nil
+}
+
+// URL
+//
+//	@Description: 生成授权地址
+//	@receiver r
+//	@return string
+func (r *AuthorizeRequest) URL() string {
+	values := url.Values{
+		"client_id":             {r.Config.ClientID},
+		"audience":              {r.Config.Audience},
+		"redirect_uri":          {r.Config.RedirectURI},
+		"scope":                 {r.Config.Scope},
+		"response_type":         {"code"},
+		"code_challenge":        {r.CodeChallenge},
+		"code_challenge_method": {"S256"},
+		"state":                 {r.State},
+		"nonce":                 {r.Nonce},
+		"prompt":                {r.Config.Prompt},
+	}
+	if r.PreauthCookie != "" {
+		values.Set("preauth_cookie", r.PreauthCookie)
+	}
+	//空格使用%20而不是+
+	return r.Config.AuthorizeURL + "?" + strings.ReplaceAll(values.Encode(), "+", "%20")
+}
+
+// ParseCallback
+//
+//	@Description: 解析回调地址, 校验state后返回code
+//	@receiver r
+//	@param callbackURL
+//	@return string
+//	@return error
+func (r *AuthorizeRequest) ParseCallback(callbackURL string) (string, error) {
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return "", fmt.Errorf("error parsing callback url: %v", err)
+	}
+	params := u.Query()
+	if errorParam := params.Get("error"); errorParam != "" {
+		return "", fmt.Errorf("%s: %s", errorParam, params.Get("error_description"))
+	}
+	if params.Get("state") != r.State {
+		return "", ErrStateMismatch
+	}
+	code := params.Get("code")
+	if code == "" {
+		return "", fmt.Errorf("error getting code from callback url: %v", callbackURL)
+	}
+	return code, nil
+}
+
+// VerifyIDToken
+//
+//	@Description: 校验id token 中的nonce, 不校验签名
+//	@receiver r
+//	@param idToken
+//	@return error
+func (r *AuthorizeRequest) VerifyIDToken(idToken string) error {
+	if idToken == "" {
+		return errors.New("id token is empty")
+	}
+	var claims struct {
+		Nonce string `json:"nonce"`
+	}
+	if err := utils.JwtClaims(idToken, &claims); err != nil {
+		return err
+	}
+	if claims.Nonce != r.Nonce {
+		return ErrNonceMismatch
+	}
+	return nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"encoding/base64"
+	"net/url"
+	"testing"
+)
+
+func TestAuthorizeRequest(t *testing.T) {
+	req, err := NewAuthorizeRequest(AuthorizeConfig{})
+	if err != nil {
+		t.Fatalf("new authorize request: %v", err)
+	}
+	other, _ := NewAuthorizeRequest(AuthorizeConfig{})
+	if req.State == other.State || req.Nonce == other.Nonce || req.CodeVerifier == other.CodeVerifier {
+		t.Error("each request should generate fresh values")
+	}
+	req.PreauthCookie = "cookie"
+	u, err := url.Parse(req.URL())
+	if err != nil {
+		t.Fatalf("parse url: %v", err)
+	}
+	q := u.Query()
+	if u.Host != "auth0.openai.com" || q.Get("client_id") != DefaultClientID || q.Get("scope") != DefaultScope {
+		t.Errorf("unexpected authorize url: %s", req.URL())
+	}
+	if q.Get("code_challenge") != req.CodeChallenge || q.Get("state") != req.State || q.Get("nonce") != req.Nonce || q.Get("preauth_cookie") != "cookie" {
+		t.Errorf("authorize url should carry request values: %s", req.URL())
+	}
+
+	callback := DefaultRedirectURI + "?code=abc&state=" + url.QueryEscape(req.State)
+	if code, err := req.ParseCallback(callback); err != nil || code != "abc" {
+		t.Errorf("unexpected callback result: %v %v", code, err)
+	}
+	if _, err := req.ParseCallback(DefaultRedirectURI + "?code=abc&state=forged"); err != ErrStateMismatch {
+		t.Errorf("state mismatch expected: %v", err)
+	}
+
+	idToken := func(nonce string) string {
+		payload := base64.RawURLEncoding.EncodeToString([]byte(`{"nonce":"` + nonce + `"}`))
+		return "e30." + payload + ".sig"
+	}
+	if err := req.VerifyIDToken(idToken(req.Nonce)); err != nil {
+		t.Errorf("verify id token: %v", err)
+	}
+	if err := req.VerifyIDToken(idToken("replayed")); err != ErrNonceMismatch {
+		t.Errorf("nonce mismatch expected: %v", err)
+	}
+}
@@ -0,0 +1,395 @@
+package opaitokens
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fireinrain/opaitokens/model"
+	"strings"
+	"time"
+)
+
+// CredentialSource 获取access token 的凭证
+// 新的凭证类型只需实现该接口即可用于share token, 续期以及pool的所有操作
+type CredentialSource interface {
+	// Name 凭证所属的账号, 用于日志和结果展示
+	Name() string
+	// Token 获取openai 的access token, 可能同时返回refresh token 和id token
+	Token() (model.OpenaiToken, error)
+}
+
+// configuredSource 内置的凭证类型, 使用FakeOpenTokens 的配置获取token
+type configuredSource interface {
+	tokenWith(cfg *config) (model.OpenaiToken, error)
+}
+
+// poolKeySource 可以直接作为pool成员的凭证(如sk key), 不需要注册share token
+type poolKeySource interface {
+	PoolKey() string
+}
+
+// asPoolKeySource 同时识别被WithShareTokenOptions 包装的poolKeySource
+func asPoolKeySource(src CredentialSource) (poolKeySource, bool) {
+	if wrapped, ok := src.(optionsSource); ok {
+		src = wrapped.CredentialSource
+	}
+	keySource, ok := src.(poolKeySource)
+	return keySource, ok
+}
+
+// PasswordSource 账号密码(以及MFA)登录
+type PasswordSource struct {
+	Email    string
+	Password string
+	MFA      string
+}
+
+func (s PasswordSource) Name() string {
+	return s.Email
+}
+
+func (s PasswordSource) Token() (model.OpenaiToken, error) {
+	return s.tokenWith(sharedDefaultConfig())
+}
+
+func (s PasswordSource) tokenWith(cfg *config) (model.OpenaiToken, error) {
+	if s.Email == "" || s.Password == "" {
+		return model.OpenaiToken{}, errors.New("email and password are required")
+	}
+	tokens := NewOpaiTokensWithMFA(s.Email, s.Password, s.MFA, true)
+	tokens.cfg = cfg
+	token := tokens.FetchToken()
+	if token.OpenaiToken.AccessToken == "" {
+		return model.OpenaiToken{}, errors.New("login failed for " + s.Email)
+	}
+	return token.OpenaiToken, nil
+}
+
+// RefreshTokenSource 使用openai 官方的refresh token
+type RefreshTokenSource struct {
+	Email        string
+	RefreshToken string
+}
+
+func (s RefreshTokenSource) Name() string {
+	return s.Email
+}
+
+func (s RefreshTokenSource) Token() (model.OpenaiToken, error) {
+	return s.tokenWith(sharedDefaultConfig())
+}
+
+func (s RefreshTokenSource) tokenWith(cfg *config) (model.OpenaiToken, error) {
+	tokens := OpaiTokens{cfg: cfg}
+	token, err := tokens.refreshToken(s.RefreshToken)
+	if err != nil {
+		return model.OpenaiToken{}, fmt.Errorf("refresh token failed: %w", err)
+	}
+	if token.AccessToken == "" {
+		return model.OpenaiToken{}, errors.New("refresh token returned empty access token for " + s.Email)
+	}
+	//轮换后旧的refresh token 已失效, 返回新的
+	refreshToken := token.RefreshToken
+	if refreshToken == "" {
+		refreshToken = s.RefreshToken
+	}
+	return model.OpenaiToken{
+		AccessToken:  token.AccessToken,
+		RefreshToken: refreshToken,
+		IDToken:      token.IDToken,
+		Scope:        token.Scope,
+		ExpiresIn:    token.ExpiresIn,
+		TokenType:    token.TokenType,
+	}, nil
+}
+
+// SessionTokenSource 使用openai 的session token(有效期90天)
+type SessionTokenSource struct {
+	Email        string
+	SessionToken string
+}
+
+func (s SessionTokenSource) Name() string {
+	return s.Email
+}
+
+func (s SessionTokenSource) Token() (model.OpenaiToken, error) {
+	return s.tokenWith(sharedDefaultConfig())
+}
+
+func (s SessionTokenSource) tokenWith(cfg *config) (model.OpenaiToken, error) {
+	token, err := cfg.platform.GetAccessTokenBySessionToken(s.SessionToken)
+	if err != nil {
+		return model.OpenaiToken{}, err
+	}
+	if token.AccessToken == "" {
+		return model.OpenaiToken{}, errors.New("session token returned empty access token for " + s.Email)
+	}
+	return model.OpenaiToken{AccessToken: token.AccessToken, ExpiresIn: token.ExpiresIn, TokenType: token.TokenType}, nil
+}
+
+// AccessTokenSource 已经获取到的access token
+type AccessTokenSource struct {
+	Email       string
+	AccessToken string
+}
+
+func (s AccessTokenSource) Name() string {
+	return s.Email
+}
+
+func (s AccessTokenSource) Token() (model.OpenaiToken, error) {
+	if s.AccessToken == "" {
+		return model.OpenaiToken{}, errors.New("access token is empty for " + s.Email)
+	}
+	return model.OpenaiToken{AccessToken: s.AccessToken}, nil
+}
+
+// SkKeySource openai 的api key, 只能直接加入pool
+type SkKeySource struct {
+	Key string
+}
+
+func (s SkKeySource) Name() string {
+	return maskKey(s.Key)
+}
+
+func (s SkKeySource) Token() (model.OpenaiToken, error) {
+	return model.OpenaiToken{}, errors.New("sk key cannot be used to get access token")
+}
+
+func (s SkKeySource) PoolKey() string {
+	return s.Key
+}
+
+// Source
+//
+//	@Description: 根据账号已有的凭证选择CredentialSource
+//	优先级 refresh token > session token > 密码
+//	@receiver a
+//	@return CredentialSource
+func (a OpenaiAccount) Source() CredentialSource {
+	if a.RefreshToken != "" {
+		return RefreshTokenSource{Email: a.Email, RefreshToken: a.RefreshToken}
+	}
+	if a.SessionToken != "" {
+		return SessionTokenSource{Email: a.Email, SessionToken: a.SessionToken}
+	}
+	return PasswordSource{Email: a.Email, Password: a.Password, MFA: a.MFA}
+}
+
+func (a OpenaiAccount) Name() string {
+	return a.Email
+}
+
+func (a OpenaiAccount) Token() (model.OpenaiToken, error) {
+	return a.tokenWith(sharedDefaultConfig())
+}
+
+func (a OpenaiAccount) tokenWith(cfg *config) (model.OpenaiToken, error) {
+	if a.RefreshToken == "" && a.SessionToken == "" && a.Password == "" {
+		return model.OpenaiToken{}, errors.New("password, refresh token or session token is required for " + a.Email)
+	}
+	if a.Proxy != "" {
+		proxied, err := cfg.withProxy(a.Proxy)
+		if err != nil {
+			return model.OpenaiToken{}, fmt.Errorf("invalid proxy for %s: %w", a.Email, err)
+		}
+		cfg = proxied
+	}
+	return a.Source().(configuredSource).tokenWith(cfg)
+}
+
+// sourceToken
+//
+//	@Description: 内置凭证使用receiver 的配置, 其他凭证直接调用Token
+//	@receiver receiver
+//	@param src
+//	@return model.OpenaiToken
+//	@return error
+func (receiver *FakeOpenTokens) sourceToken(src CredentialSource) (model.OpenaiToken, error) {
+	if s, ok := src.(configuredSource); ok {
+		return s.tokenWith(receiver.conf())
+	}
+	return src.Token()
+}
+
+// AccountSources
+//
+//	@Description: 将账号列表转换为CredentialSource列表
+//	@param accounts
+//	@return []CredentialSource
+func AccountSources(accounts []OpenaiAccount) []CredentialSource {
+	srcs := make([]CredentialSource, 0, len(accounts))
+	for _, account := range accounts {
+		srcs = append(srcs, account)
+	}
+	return srcs
+}
+
+// RefreshTokenSources
+//
+//	@Description: 将refresh token 列表转换为CredentialSource列表
+//	@param renewSharedTokenRFTs
+//	@return []CredentialSource
+func RefreshTokenSources(renewSharedTokenRFTs []RenewSharedTokenRFT) []CredentialSource {
+	srcs := make([]CredentialSource, 0, len(renewSharedTokenRFTs))
+	for _, rft := range renewSharedTokenRFTs {
+		srcs = append(srcs, RefreshTokenSource{Email: rft.OpenaiAccountEmail, RefreshToken: rft.OpenaiRefreshToken})
+	}
+	return srcs
+}
+
+// SkKeySources
+//
+//	@Description: 将sk key 列表转换为CredentialSource列表
+//	@param skKeys
+//	@return []CredentialSource
+func SkKeySources(skKeys []string) []CredentialSource {
+	srcs := make([]CredentialSource, 0, len(skKeys))
+	for _, key := range skKeys {
+		srcs = append(srcs, SkKeySource{Key: key})
+	}
+	return srcs
+}
+
+// RenewSharedTokens
+//
+//	@Description: 使用任意凭证刷新share token
+//	@receiver receiver
+//	@param srcs
+//	@param uniqueName
+//	@return RenewResult
+//	@return error 最后一个失败的错误
+func (receiver *FakeOpenTokens) RenewSharedTokens(srcs []CredentialSource, uniqueName string) (RenewResult, error) {
+	result := RenewResult{}
+	if len(srcs) <= 0 {
+		return result, errors.New("credential source list is empty")
+	}
+	var er error
+	for index, src := range srcs {
+		receiver.logf("renew shared token progress...%v/%v.", index+1, len(srcs))
+		token, err := receiver.FetchSharedToken(src, uniqueName)
+		if err == nil {
+			result.RenewCount += 1
+			if credential, ok := token.Credential(); ok {
+				result.Credentials = append(result.Credentials, credential)
+			}
+		} else {
+			er = err
+			if IsRefreshTokenRevoked(err) {
+				result.Revoked = append(result.Revoked, src.Name())
+			}
+			receiver.notifyAccountFailed(src.Name(), err)
+		}
+		if index < len(srcs)-1 {
+			receiver.conf().clock.Sleep(time.Second * 15)
+		}
+	}
+	//全部成功刷新
+	if len(srcs) == result.RenewCount {
+		result.RenewSuccess = true
+	}
+	return result, er
+}
+
+// RevokeSharedTokens
+//
+//	@Description: 撤销所有账号在uniqueName 下的fk
+//	@receiver receiver
+//	@param srcs
+//	@param uniqueName
+//	@return RevokeResult
+//	@return error 最后一个失败账号的错误
+func (receiver *FakeOpenTokens) RevokeSharedTokens(srcs []CredentialSource, uniqueName string) (RevokeResult, error) {
+	result := RevokeResult{}
+	if len(srcs) <= 0 {
+		return result, errors.New("credential source list is empty")
+	}
+	var er error
+	for index, src := range srcs {
+		receiver.logf("revoke shared token progress...%v/%v.", index+1, len(srcs))
+		if _, err := receiver.RevokeSharedToken(src, uniqueName); err == nil {
+			result.RevokeCount += 1
+		} else {
+			er = err
+			result.Failed = append(result.Failed, src.Name())
+			receiver.notifyAccountFailed(src.Name(), err)
+		}
+		if index < len(srcs)-1 {
+			receiver.conf().clock.Sleep(time.Second * 15)
+		}
+	}
+	if len(srcs) == result.RevokeCount {
+		result.RevokeSuccess = true
+	}
+	return result, er
+}
+
+// BuildPool
+//
+//	@Description: 使用任意凭证注册pool token, sk key 直接作为成员
+//	@receiver receiver
+//	@param srcs
+//	@param uniqueName
+//	@return PooledTokenResult
+//	@return error
+func (receiver *FakeOpenTokens) BuildPool(srcs []CredentialSource, uniqueName string) (PooledTokenResult, error) {
+	if len(srcs) <= 0 {
+		return PooledTokenResult{}, errors.New("credential source list is empty")
+	}
+	if len(srcs) > PooledTokenAccountsLimit {
+		receiver.logf("credential source size is greater than 100,do cut off to 100")
+	}
+	candidates, skKeys := receiver.sourceCandidates(srcs, uniqueName)
+	return receiver.buildPool(candidates, skKeys, "")
+}
+
+// sourceCandidates
+//
+//	@Description: 将凭证分为需要获取share token 的成员和可以直接加入pool的key
+//	@receiver receiver
+//	@param srcs
+//	@param uniqueName
+//	@return []poolCandidate
+//	@return []string
+func (receiver *FakeOpenTokens) sourceCandidates(srcs []CredentialSource, uniqueName string) ([]poolCandidate, []string) {
+	var candidates []poolCandidate
+	var keys []string
+	for _, src := range srcs {
+		if keySource, ok := asPoolKeySource(src); ok {
+			keys = append(keys, keySource.PoolKey())
+			continue
+		}
+		src := src
+		candidates = append(candidates, poolCandidate{
+			member: src.Name(),
+			fetch: func() (SharedTokenResult, error) {
+				return receiver.FetchSharedToken(src, uniqueName)
+			},
+		})
+	}
+	return candidates, keys
+}
+
+// ApplyCredentials
+//
+//	@Description: 将获取到的refresh token 写回账号, 之后续期使用refresh token 而不是密码登录
+//	@param accounts
+//	@param credentials
+//	@return []OpenaiAccount
+func ApplyCredentials(accounts []OpenaiAccount, credentials []AccountCredential) []OpenaiAccount {
+	refreshTokens := make(map[string]string)
+	for _, c := range credentials {
+		if c.RefreshToken != "" {
+			refreshTokens[strings.ToLower(c.Email)] = c.RefreshToken
+		}
+	}
+	updated := make([]OpenaiAccount, 0, len(accounts))
+	for _, account := range accounts {
+		if refreshToken, ok := refreshTokens[strings.ToLower(account.Email)]; ok {
+			account.RefreshToken = refreshToken
+		}
+		updated = append(updated, account)
+	}
+	return updated
+}
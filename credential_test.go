@@ -0,0 +1,132 @@
+package opaitokens
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fireinrain/opaitokens/fakeopen"
+	"github.com/fireinrain/opaitokens/internal/transport"
+	"github.com/fireinrain/opaitokens/model"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestOpenaiAccountSource(t *testing.T) {
+	if _, ok := (OpenaiAccount{Email: "a", Password: "p", RefreshToken: "r"}).Source().(RefreshTokenSource); !ok {
+		t.Error("refresh token should be preferred")
+	}
+	if _, ok := (OpenaiAccount{Email: "a", Password: "p", SessionToken: "s"}).Source().(SessionTokenSource); !ok {
+		t.Error("session token should be preferred over password")
+	}
+	if _, ok := (OpenaiAccount{Email: "a", Password: "p"}).Source().(PasswordSource); !ok {
+		t.Error("password source expected")
+	}
+	if _, err := (OpenaiAccount{Email: "a"}).Token(); err == nil {
+		t.Error("account without credential should fail")
+	}
+}
+
+func TestSourceCandidates(t *testing.T) {
+	skKey := "sk-1234567890abcdefghijabcd"
+	srcs := append(AccountSources([]OpenaiAccount{{Email: "a@example.com", Password: "p"}}),
+		AccessTokenSource{Email: "b@example.com", AccessToken: "eyJ"}, SkKeySource{Key: skKey})
+	receiver := &FakeOpenTokens{}
+	candidates, keys := receiver.sourceCandidates(srcs, "test")
+	if len(candidates) != 2 || candidates[1].member != "b@example.com" {
+		t.Fatalf("unexpected candidates: %v", candidates)
+	}
+	if len(keys) != 1 || keys[0] != skKey {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+	if token, err := (AccessTokenSource{Email: "b", AccessToken: "eyJ"}).Token(); err != nil || token.AccessToken != "eyJ" {
+		t.Errorf("unexpected access token: %v %v", token, err)
+	}
+}
+
+func TestApplyCredentials(t *testing.T) {
+	accounts := []OpenaiAccount{{Email: "A@example.com", Password: "p"}, {Email: "b@example.com", Password: "p"}}
+	result := SharedTokenResult{Email: "a@example.com", RefreshToken: "rt-a"}
+	credential, ok := result.Credential()
+	if !ok {
+		t.Fatal("credential expected")
+	}
+	if _, ok := (SharedTokenResult{Email: "b@example.com"}).Credential(); ok {
+		t.Error("no credential expected without refresh token")
+	}
+	updated := ApplyCredentials(accounts, []AccountCredential{credential})
+	if updated[0].RefreshToken != "rt-a" || updated[1].RefreshToken != "" {
+		t.Errorf("unexpected accounts: %+v", updated)
+	}
+	if _, ok := updated[0].Source().(RefreshTokenSource); !ok {
+		t.Error("account should switch to refresh token source")
+	}
+}
+
+func TestAccountProxy(t *testing.T) {
+	var proxiedHost string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//作为http 代理收到的是完整的请求地址
+		proxiedHost = r.URL.Host
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"at-proxied"}`))
+	}))
+	defer proxy.Close()
+
+	tokens := NewFakeOpenTokens(WithFakeOpenBaseURL("http://fakeopen.invalid"))
+	account := OpenaiAccount{Email: "a@example.com", SessionToken: "st", Proxy: proxy.URL}
+	token, err := tokens.sourceToken(account)
+	if err != nil || token.AccessToken != "at-proxied" {
+		t.Fatalf("unexpected token: %+v %v", token, err)
+	}
+	if proxiedHost != "fakeopen.invalid" {
+		t.Errorf("request should go through the account proxy, got host %q", proxiedHost)
+	}
+	if tokens.conf().platform.(*fakeopen.AiFakeOpenPlatform).Client != transport.Default() {
+		t.Error("account proxy should not change the shared platform client")
+	}
+}
+
+// rewriteTransport 将所有请求转发到测试服务, 用于替换固定的auth0 地址
+type rewriteTransport struct {
+	target string
+}
+
+func (t rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u, _ := url.Parse(t.target)
+	req = req.Clone(req.Context())
+	req.URL.Scheme = u.Scheme
+	req.URL.Host = u.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// refreshServer 模拟auth0 的refresh grant, 每次刷新都轮换refresh token
+func refreshServer(t *testing.T) (*httptest.Server, *[]string) {
+	var used []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req model.OpenaiTokenRereshReq
+		json.NewDecoder(r.Body).Decode(&req)
+		used = append(used, req.RefreshToken)
+		w.Header().Set("Content-Type", "application/json")
+		if len(used) > 1 && req.RefreshToken != fmt.Sprintf("rt-%d", len(used)-1) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+		fmt.Fprintf(w, `{"access_token": "at-%d", "refresh_token": "rt-%d"}`, len(used), len(used))
+	}))
+	t.Cleanup(server.Close)
+	return server, &used
+}
+
+func TestRefreshTokenSourceRotation(t *testing.T) {
+	server, used := refreshServer(t)
+	tokens := NewFakeOpenTokens(WithHTTPClient(&http.Client{Transport: rewriteTransport{target: server.URL}}))
+	token, err := tokens.sourceToken(RefreshTokenSource{Email: "a@example.com", RefreshToken: "rt-0"})
+	if err != nil || token.AccessToken != "at-1" || token.RefreshToken != "rt-1" {
+		t.Fatalf("rotated refresh token should be returned: %+v %v", token, err)
+	}
+	if _, err := tokens.sourceToken(RefreshTokenSource{Email: "a@example.com", RefreshToken: token.RefreshToken}); err != nil {
+		t.Fatalf("rotated refresh token should work: %v %v", err, *used)
+	}
+}
@@ -0,0 +1,294 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fireinrain/opaitokens"
+	"github.com/fireinrain/opaitokens/fakeopen"
+	"github.com/fireinrain/opaitokens/utils"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// 将获取到的token导出为各种客户端使用的格式
+
+type Format string
+
+const (
+	FormatDotenv Format = "dotenv"
+	FormatJSON   Format = "json"
+	FormatYAML   Format = "yaml"
+	FormatShell  Format = "shell"
+	// FormatPandoraTokens pandora 的 tokens.json, 格式为 {"email": "access token"}
+	FormatPandoraTokens Format = "pandora-tokens"
+	// FormatShareTokens 每行一个share token
+	FormatShareTokens Format = "share-tokens"
+)
+
+// Bundle 需要导出的token
+type Bundle struct {
+	Accounts     []*opaitokens.OpaiTokens
+	SharedTokens []fakeopen.SharedToken
+	PooledTokens []fakeopen.PooledToken
+}
+
+// AccountToken 导出的账号token, 不包含密码和MFA
+type AccountToken struct {
+	Email        string `json:"email"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	ExpiresIn    int    `json:"expires_in,omitempty"`
+}
+
+// Document 导出json/yaml以及模板时使用的数据
+type Document struct {
+	Accounts     []AccountToken         `json:"accounts"`
+	SharedTokens []fakeopen.SharedToken `json:"share_tokens"`
+	PooledTokens []fakeopen.PooledToken `json:"pool_tokens"`
+}
+
+// Variable 导出为环境变量时的键值
+type Variable struct {
+	Name  string
+	Value string
+}
+
+// NewDocument
+//
+//	@Description: 整理bundle, 优先使用刷新后的access token
+//	@param bundle
+//	@return Document
+func NewDocument(bundle Bundle) Document {
+	doc := Document{
+		Accounts:     []AccountToken{},
+		SharedTokens: bundle.SharedTokens,
+		PooledTokens: bundle.PooledTokens,
+	}
+	if doc.SharedTokens == nil {
+		doc.SharedTokens = []fakeopen.SharedToken{}
+	}
+	if doc.PooledTokens == nil {
+		doc.PooledTokens = []fakeopen.PooledToken{}
+	}
+	for _, tokens := range bundle.Accounts {
+		if tokens == nil {
+			continue
+		}
+		account := AccountToken{
+			Email:        tokens.Email,
+			AccessToken:  tokens.OpenaiToken.AccessToken,
+			RefreshToken: tokens.OpenaiToken.RefreshToken,
+			IDToken:      tokens.OpenaiToken.IDToken,
+			ExpiresIn:    tokens.OpenaiToken.ExpiresIn,
+		}
+		if tokens.RefreshedToken.AccessToken != "" {
+			account.AccessToken = tokens.RefreshedToken.AccessToken
+			account.ExpiresIn = tokens.RefreshedToken.ExpiresIn
+			if tokens.RefreshedToken.IDToken != "" {
+				account.IDToken = tokens.RefreshedToken.IDToken
+			}
+		}
+		doc.Accounts = append(doc.Accounts, account)
+	}
+	return doc
+}
+
+// Variables
+//
+//	@Description: 生成环境变量, 多个值使用序号后缀 如 ACCESS_TOKEN_1
+//	只有一个pool token时额外输出不带序号的 POOL_TOKEN
+//	@param bundle
+//	@param prefix 变量名前缀, 可以为空
+//	@return []Variable
+func Variables(bundle Bundle, prefix string) []Variable {
+	doc := NewDocument(bundle)
+	var vars []Variable
+	add := func(name string, value string) {
+		if value != "" {
+			vars = append(vars, Variable{Name: prefix + name, Value: value})
+		}
+	}
+	for i, account := range doc.Accounts {
+		n := strconv.Itoa(i + 1)
+		add("EMAIL_"+n, account.Email)
+		add("ACCESS_TOKEN_"+n, account.AccessToken)
+		add("REFRESH_TOKEN_"+n, account.RefreshToken)
+	}
+	for i, token := range doc.SharedTokens {
+		add("SHARE_TOKEN_"+strconv.Itoa(i+1), token.TokenKey)
+	}
+	if len(doc.PooledTokens) == 1 {
+		add("POOL_TOKEN", doc.PooledTokens[0].PoolToken)
+	}
+	for i, token := range doc.PooledTokens {
+		add("POOL_TOKEN_"+strconv.Itoa(i+1), token.PoolToken)
+	}
+	return vars
+}
+
+// Render
+//
+//	@Description: 按格式渲染bundle
+//	@param format
+//	@param bundle
+//	@return []byte
+//	@return error
+func Render(format Format, bundle Bundle) ([]byte, error) {
+	switch format {
+	case FormatDotenv:
+		return renderVariables(bundle, func(v Variable) string {
+			return v.Name + "=" + strconv.Quote(v.Value)
+		}), nil
+	case FormatShell:
+		return renderVariables(bundle, func(v Variable) string {
+			return "export " + v.Name + "=" + shellQuote(v.Value)
+		}), nil
+	case FormatJSON:
+		data, err := json.MarshalIndent(NewDocument(bundle), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case FormatYAML:
+		return renderYAML(NewDocument(bundle)), nil
+	case FormatPandoraTokens:
+		tokens := make(map[string]string)
+		for _, account := range NewDocument(bundle).Accounts {
+			tokens[account.Email] = account.AccessToken
+		}
+		data, err := json.MarshalIndent(tokens, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case FormatShareTokens:
+		buf := &bytes.Buffer{}
+		for _, token := range bundle.SharedTokens {
+			if token.TokenKey != "" {
+				buf.WriteString(token.TokenKey + "\n")
+			}
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, errors.New("unsupported export format: " + string(format))
+}
+
+// RenderTemplate
+//
+//	@Description: 使用go text/template渲染, 模板数据为Document, 并提供env函数获取Variables
+//	@param text
+//	@param bundle
+//	@return []byte
+//	@return error
+func RenderTemplate(text string, bundle Bundle) ([]byte, error) {
+	funcs := template.FuncMap{
+		"env": func(prefix string) []Variable {
+			return Variables(bundle, prefix)
+		},
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}
+	tmpl, err := template.New("export").Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.New("parse export template failed: " + err.Error())
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, NewDocument(bundle)); err != nil {
+		return nil, errors.New("execute export template failed: " + err.Error())
+	}
+	return buf.Bytes(), nil
+}
+
+// Export
+//
+//	@Description: 渲染并写入文件
+//	@param path
+//	@param format
+//	@param bundle
+//	@return error
+func Export(path string, format Format, bundle Bundle) error {
+	data, err := Render(format, bundle)
+	if err != nil {
+		return err
+	}
+	return WriteFile(path, data)
+}
+
+// WriteFile
+//
+//	@Description: 原子写入文件, 文件权限为0600
+//	@param path
+//	@param data
+//	@return error
+func WriteFile(path string, data []byte) error {
+	err := utils.WriteFileAtomic(path, data, 0600)
+	if err != nil {
+		return errors.New("write export file failed: " + err.Error())
+	}
+	return nil
+}
+
+func renderVariables(bundle Bundle, line func(v Variable) string) []byte {
+	buf := &bytes.Buffer{}
+	for _, v := range Variables(bundle, "") {
+		buf.WriteString(line(v) + "\n")
+	}
+	return buf.Bytes()
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// renderYAML 输出Document的yaml, 字符串使用双引号避免特殊字符问题
+func renderYAML(doc Document) []byte {
+	buf := &bytes.Buffer{}
+	q := strconv.Quote
+	buf.WriteString("accounts:")
+	if len(doc.Accounts) == 0 {
+		buf.WriteString(" []")
+	}
+	buf.WriteString("\n")
+	for _, a := range doc.Accounts {
+		fmt.Fprintf(buf, "  - email: %s\n", q(a.Email))
+		fmt.Fprintf(buf, "    access_token: %s\n", q(a.AccessToken))
+		if a.RefreshToken != "" {
+			fmt.Fprintf(buf, "    refresh_token: %s\n", q(a.RefreshToken))
+		}
+		if a.IDToken != "" {
+			fmt.Fprintf(buf, "    id_token: %s\n", q(a.IDToken))
+		}
+		if a.ExpiresIn != 0 {
+			fmt.Fprintf(buf, "    expires_in: %d\n", a.ExpiresIn)
+		}
+	}
+	buf.WriteString("share_tokens:")
+	if len(doc.SharedTokens) == 0 {
+		buf.WriteString(" []")
+	}
+	buf.WriteString("\n")
+	for _, s := range doc.SharedTokens {
+		fmt.Fprintf(buf, "  - token_key: %s\n", q(s.TokenKey))
+		fmt.Fprintf(buf, "    unique_name: %s\n", q(s.UniqueName))
+		fmt.Fprintf(buf, "    expire_at: %d\n", s.ExpireAt)
+		fmt.Fprintf(buf, "    site_limit: %s\n", q(s.SiteLimit))
+		fmt.Fprintf(buf, "    show_conversations: %t\n", s.ShowConversations)
+		fmt.Fprintf(buf, "    show_userinfo: %t\n", s.ShowUserinfo)
+	}
+	buf.WriteString("pool_tokens:")
+	if len(doc.PooledTokens) == 0 {
+		buf.WriteString(" []")
+	}
+	buf.WriteString("\n")
+	for _, p := range doc.PooledTokens {
+		fmt.Fprintf(buf, "  - pool_token: %s\n", q(p.PoolToken))
+		fmt.Fprintf(buf, "    count: %d\n", p.Count)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,73 @@
+package export
+
+import (
+	"github.com/fireinrain/opaitokens"
+	"github.com/fireinrain/opaitokens/fakeopen"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testBundle() Bundle {
+	tokens := &opaitokens.OpaiTokens{Email: "a@example.com", Password: "secret"}
+	tokens.OpenaiToken.AccessToken = "eyJ-access"
+	tokens.OpenaiToken.RefreshToken = "refresh-x"
+	return Bundle{
+		Accounts:     []*opaitokens.OpaiTokens{tokens},
+		SharedTokens: []fakeopen.SharedToken{{TokenKey: "fk-aaaa", UniqueName: "fireinrain"}},
+		PooledTokens: []fakeopen.PooledToken{{PoolToken: "pk-bbbb", Count: 1}},
+	}
+}
+
+func TestRender(t *testing.T) {
+	bundle := testBundle()
+	dotenv, _ := Render(FormatDotenv, bundle)
+	if !strings.Contains(string(dotenv), "ACCESS_TOKEN_1=\"eyJ-access\"\n") || !strings.Contains(string(dotenv), "POOL_TOKEN=\"pk-bbbb\"\n") {
+		t.Errorf("unexpected dotenv: %s", dotenv)
+	}
+	shell, _ := Render(FormatShell, bundle)
+	if !strings.Contains(string(shell), "export SHARE_TOKEN_1='fk-aaaa'\n") {
+		t.Errorf("unexpected shell: %s", shell)
+	}
+	for _, format := range []Format{FormatJSON, FormatYAML, FormatDotenv, FormatShell} {
+		data, err := Render(format, bundle)
+		if err != nil {
+			t.Fatalf("render %s: %v", format, err)
+		}
+		if strings.Contains(string(data), "secret") {
+			t.Errorf("%s export contains password", format)
+		}
+	}
+	pandora, _ := Render(FormatPandoraTokens, bundle)
+	if !strings.Contains(string(pandora), `"a@example.com": "eyJ-access"`) {
+		t.Errorf("unexpected pandora tokens: %s", pandora)
+	}
+	if _, err := Render(Format("toml"), bundle); err == nil {
+		t.Error("expected error for unsupported format")
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	data, err := RenderTemplate(`{{range .SharedTokens}}{{.TokenKey}}{{end}} {{range env "APP_"}}{{.Name}} {{end}}`, testBundle())
+	if err != nil {
+		t.Fatalf("render template: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "fk-aaaa APP_EMAIL_1 ") {
+		t.Errorf("unexpected template output: %s", data)
+	}
+}
+
+func TestExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := Export(path, FormatDotenv, testBundle()); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat export file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected file mode: %v", info.Mode())
+	}
+}
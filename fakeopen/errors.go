@@ -0,0 +1,96 @@
+package fakeopen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// ErrPlatformUnavailable 网络错误, 5xx(包括502页面等非json内容), 429 或2xx 返回了非json内容, 可以稍后重试
+var ErrPlatformUnavailable = errors.New("fakeopen platform unavailable")
+
+// ErrInvalidAccessToken access token 无效或过期, 需要重新获取access token
+var ErrInvalidAccessToken = errors.New("invalid access token")
+
+// ErrEmptyToken 请求成功但返回的token_key/pool_token 为空
+var ErrEmptyToken = errors.New("fakeopen returned empty token")
+
+// ErrTokenNotRevoked 撤销后fakeopen 返回的fk 仍未过期
+var ErrTokenNotRevoked = errors.New("shared token not revoked")
+
+// ErrTokenNotFound 查询的fk/pk 不存在
+var ErrTokenNotFound = errors.New("token not found")
+
+// PlatformError fakeopen 返回的非2xx json 响应, 如 {"detail": "..."}
+type PlatformError struct {
+	Status int
+	Detail string
+}
+
+func (e *PlatformError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("fakeopen error (status %d)", e.Status)
+	}
+	return fmt.Sprintf("fakeopen error (status %d): %s", e.Status, e.Detail)
+}
+
+// Is 5xx 和 429 视为ErrPlatformUnavailable, 404 视为ErrTokenNotFound, 401/403 或detail 中提到access token 时视为ErrInvalidAccessToken
+func (e *PlatformError) Is(target error) bool {
+	switch target {
+	case ErrPlatformUnavailable:
+		return e.Status >= 500 || e.Status == 429
+	case ErrTokenNotFound:
+		return e.Status == 404
+	case ErrInvalidAccessToken:
+		return e.Status == 401 || e.Status == 403 ||
+			(e.Status >= 400 && e.Status < 500 && strings.Contains(strings.ToLower(e.Detail), "access token"))
+	}
+	return false
+}
+
+// parsePlatformError
+//
+//	@Description: 解析非2xx 响应中的detail, detail 可能是字符串也可能是校验错误列表
+//	@param status
+//	@param body
+//	@return *PlatformError
+func parsePlatformError(status int, body []byte) *PlatformError {
+	platformErr := &PlatformError{Status: status}
+	var resp struct {
+		Detail json.RawMessage `json:"detail"`
+	}
+	if err := json.Unmarshal(body, &resp); err == nil && len(resp.Detail) > 0 {
+		var detail string
+		if json.Unmarshal(resp.Detail, &detail) == nil {
+			platformErr.Detail = detail
+		} else {
+			platformErr.Detail = string(resp.Detail)
+		}
+		return platformErr
+	}
+	platformErr.Detail = strings.TrimSpace(string(body))
+	if len(platformErr.Detail) > 200 {
+		platformErr.Detail = platformErr.Detail[:200]
+	}
+	return platformErr
+}
+
+// isJSONResponse
+//
+//	@Description: 根据Content-Type 判断是否为json, 未设置或为text/plain 时检查内容
+//	@param contentType
+//	@param body
+//	@return bool
+func isJSONResponse(contentType string, body []byte) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if strings.Contains(mediaType, "json") {
+		return true
+	}
+	if mediaType != "" && mediaType != "text/plain" {
+		return false
+	}
+	trimmed := strings.TrimSpace(string(body))
+	return strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")
+}
@@ -0,0 +1,61 @@
+package fakeopen
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPlatformErrors(t *testing.T) {
+	cases := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		want        error
+	}{
+		{"invalid access token", 400, "application/json", `{"detail":"Invalid access token"}`, ErrInvalidAccessToken},
+		{"unauthorized", 401, "application/json", `{"detail":"expired"}`, ErrInvalidAccessToken},
+		{"bad gateway page", 502, "text/html", `<html><body>502 Bad Gateway</body></html>`, ErrPlatformUnavailable},
+		{"non-json success", 200, "text/html", `<html><body>maintenance</body></html>`, ErrPlatformUnavailable},
+		{"rate limited", 429, "application/json", `{"detail":"too many requests"}`, ErrPlatformUnavailable},
+		{"empty token", 200, "application/json", `{"token_key":""}`, ErrEmptyToken},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", c.contentType)
+				w.WriteHeader(c.status)
+				w.Write([]byte(c.body))
+			}))
+			defer server.Close()
+			platform := NewAiFakeOpenPlatformWithBaseURL(server.URL)
+			_, err := platform.GetSharedToken(SharedTokenReq{UniqueName: "a", AccessToken: "b"})
+			if !errors.Is(err, c.want) {
+				t.Fatalf("err = %v, want %v", err, c.want)
+			}
+		})
+	}
+}
+
+func TestPlatformErrorDetail(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"detail":[{"loc":["body","share_tokens"],"msg":"field required"}]}`))
+	}))
+	defer server.Close()
+	platform := NewAiFakeOpenPlatformWithBaseURL(server.URL)
+	_, err := platform.RenewPooledToken(PooledTokenReq{ShareTokens: []string{"fk-a"}})
+	var platformErr *PlatformError
+	if !errors.As(err, &platformErr) {
+		t.Fatalf("err = %v, want *PlatformError", err)
+	}
+	if platformErr.Status != http.StatusUnprocessableEntity || platformErr.Detail == "" {
+		t.Fatalf("unexpected platform error %+v", platformErr)
+	}
+	if errors.Is(err, ErrPlatformUnavailable) || errors.Is(err, ErrInvalidAccessToken) {
+		t.Fatalf("validation error should not match sentinel errors: %v", err)
+	}
+}
@@ -1,12 +1,12 @@
 package fakeopen
 
 import (
-	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/fireinrain/opaitokens/internal/transport"
 	"io"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"strconv"
 	"strings"
@@ -17,33 +17,133 @@ import (
 // https://ai.fakeopen.com/token
 // https://ai.fakeopen.com/pool
 
-const SharedTokenRegisterUrl = "https://ai.fakeopen.com/token/register"
-const PooledTokenRegisterUrl = "https://ai.fakeopen.com/pool/update"
-const SessionTokenGenACTUrl = "https://ai.fakeopen.com/auth/session"
+// DefaultBaseURL 默认的fakeopen 地址, 也可以使用自建的pandora 兼容服务
+const DefaultBaseURL = "https://ai.fakeopen.com"
+
+const SharedTokenRegisterPath = "/token/register"
+const PooledTokenRegisterPath = "/pool/update"
+const SessionTokenGenACTPath = "/auth/session"
+const SharedTokenInfoPath = "/token/info"
+const PooledTokenInfoPath = "/pool/info"
+
+const SharedTokenRegisterUrl = DefaultBaseURL + SharedTokenRegisterPath
+const PooledTokenRegisterUrl = DefaultBaseURL + PooledTokenRegisterPath
+const SessionTokenGenACTUrl = DefaultBaseURL + SessionTokenGenACTPath
 const PooledTokensLimit = 100
 
-var jar, _ = cookiejar.New(nil)
+// RevokeExpiresIn 注册fk 时使用负数的expires_in 表示撤销
+const RevokeExpiresIn = -1
 
 type AiFakeOpenPlatform struct {
 	Client *http.Client
+	//为空时使用DefaultBaseURL
+	BaseURL string
+	//BaseURL 不可用(网络错误, 5xx, 429)时依次尝试的镜像地址
+	Mirrors []string
+	//自建服务的查询接口路径不同时指定, 为空时使用SharedTokenInfoPath/PooledTokenInfoPath
+	SharedTokenInfoPath string
+	PooledTokenInfoPath string
+	//当前时间, 用于检查撤销后的fk 是否过期, 为空时使用time.Now
+	Now func() time.Time
 }
 
 func NewAiFakeOpenPlatform() *AiFakeOpenPlatform {
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		Proxy:           http.ProxyFromEnvironment,
-	}
-	client := &http.Client{
-		Timeout:   time.Second * 100,
-		Transport: tr,
-		Jar:       jar,
-	}
 	platform := &AiFakeOpenPlatform{
-		Client: client,
+		Client: transport.Default(),
 	}
 	return platform
 }
 
+// NewAiFakeOpenPlatformWithBaseURL
+//
+//	@Description: 使用自建服务或镜像
+//	@param baseURL 如 https://pandora.example.com
+//	@param mirrors 故障时依次尝试的地址
+//	@return *AiFakeOpenPlatform
+func NewAiFakeOpenPlatformWithBaseURL(baseURL string, mirrors ...string) *AiFakeOpenPlatform {
+	platform := NewAiFakeOpenPlatform()
+	platform.BaseURL = baseURL
+	platform.Mirrors = mirrors
+	return platform
+}
+
+// now 当前时间, 未指定Now 时使用time.Now
+func (f *AiFakeOpenPlatform) now() time.Time {
+	if f.Now != nil {
+		return f.Now()
+	}
+	return time.Now()
+}
+
+// baseURLs 按顺序返回需要尝试的地址
+func (f *AiFakeOpenPlatform) baseURLs() []string {
+	base := f.BaseURL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	urls := []string{strings.TrimRight(base, "/")}
+	for _, mirror := range f.Mirrors {
+		if mirror != "" {
+			urls = append(urls, strings.TrimRight(mirror, "/"))
+		}
+	}
+	return urls
+}
+
+// postForm
+//
+//	@Description: 依次向BaseURL和镜像发送表单请求, 只在网络错误, 5xx, 429 时尝试下一个地址
+//	@receiver f
+//	@param path
+//	@param formValues
+//	@return []byte json 响应内容
+//	@return error 非2xx 时为*PlatformError(包括非json 的4xx), 所有地址不可用或2xx 返回非json 内容时匹配ErrPlatformUnavailable
+func (f *AiFakeOpenPlatform) postForm(path string, formValues url.Values) ([]byte, error) {
+	return f.do(func(client *http.Client, base string) (*http.Response, error) {
+		return client.PostForm(base+path, formValues)
+	})
+}
+
+// get 同postForm, 使用GET 请求
+func (f *AiFakeOpenPlatform) get(path string) ([]byte, error) {
+	return f.do(func(client *http.Client, base string) (*http.Response, error) {
+		return client.Get(base + path)
+	})
+}
+
+func (f *AiFakeOpenPlatform) do(send func(client *http.Client, base string) (*http.Response, error)) ([]byte, error) {
+	client := f.Client
+	if client == nil {
+		client = transport.Default()
+	}
+	var er error
+	for _, base := range f.baseURLs() {
+		resp, err := send(client, base)
+		if err != nil {
+			er = fmt.Errorf("%w: %v", ErrPlatformUnavailable, err)
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			er = fmt.Errorf("%w: read response failed: %v", ErrPlatformUnavailable, err)
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			er = parsePlatformError(resp.StatusCode, body)
+			if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+				continue
+			}
+			return nil, er
+		}
+		if !isJSONResponse(resp.Header.Get("Content-Type"), body) {
+			return nil, fmt.Errorf("%w: %s returned %s with non-json content %q", ErrPlatformUnavailable, base, resp.Status, resp.Header.Get("Content-Type"))
+		}
+		return body, nil
+	}
+	return nil, er
+}
+
 type SharedTokenReq struct {
 	//唯一表示
 	UniqueName string `url:"unique_name"`
@@ -55,6 +155,8 @@ type SharedTokenReq struct {
 	SiteLimit string `url:"site_limit"`
 	//是否显示对话历史 默认为true
 	ShowConversations bool `url:"show_conversations"`
+	//是否显示账号信息 默认为false
+	ShowUserinfo bool `url:"show_userinfo"`
 }
 
 type SharedToken struct {
@@ -80,49 +182,63 @@ type SharedToken struct {
 func (f *AiFakeOpenPlatform) GetSharedToken(shareTokenReq SharedTokenReq) (SharedToken, error) {
 	token := SharedToken{}
 
-	// Convert the struct to url.Values
-	formValues := url.Values{}
-	formValues.Set("unique_name", shareTokenReq.UniqueName)
-	formValues.Set("access_token", shareTokenReq.AccessToken)
-	formValues.Set("expires_in", strconv.Itoa(shareTokenReq.ExpiresIn))
-	formValues.Set("site_limit", shareTokenReq.SiteLimit)
-	formValues.Set("show_conversations", strconv.FormatBool(shareTokenReq.ShowConversations))
-
 	// Send the form data as a POST request
-	resp, err := f.Client.PostForm(SharedTokenRegisterUrl, formValues)
-	if err != nil {
-		return token, errors.New("get shared token failed: " + err.Error())
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err := f.postForm(SharedTokenRegisterPath, shareTokenReq.form())
 	if err != nil {
-		return token, errors.New("read response failed: " + err.Error())
+		return token, fmt.Errorf("get shared token failed: %w", err)
 	}
 
 	err = json.Unmarshal(body, &token)
 	if err != nil {
 		return token, errors.New("unmarshal response to json failed: " + err.Error())
 	}
+	if token.TokenKey == "" {
+		return token, fmt.Errorf("get shared token failed: %w", ErrEmptyToken)
+	}
 	return token, nil
 }
 
+// form Convert the struct to url.Values
+func (shareTokenReq SharedTokenReq) form() url.Values {
+	formValues := url.Values{}
+	formValues.Set("unique_name", shareTokenReq.UniqueName)
+	formValues.Set("access_token", shareTokenReq.AccessToken)
+	formValues.Set("expires_in", strconv.Itoa(shareTokenReq.ExpiresIn))
+	formValues.Set("site_limit", shareTokenReq.SiteLimit)
+	formValues.Set("show_conversations", strconv.FormatBool(shareTokenReq.ShowConversations))
+	formValues.Set("show_userinfo", strconv.FormatBool(shareTokenReq.ShowUserinfo))
+	return formValues
+}
+
 // RevokeSharedToken
 //
-//	@Description: 撤销fakeopen fk
+//	@Description: 撤销fakeopen fk, expires_in 为负数时fakeopen 会立即使该unique_name 对应的fk 失效
 //	@receiver f
 //	@param uniqueName
 //	@param accessToken
-//	@return SharedToken
-//	@return error
+//	@return SharedToken 撤销后的fk 信息, expire_at 不晚于当前时间
+//	@return error fk 仍然有效时匹配ErrTokenNotRevoked
 func (f *AiFakeOpenPlatform) RevokeSharedToken(uniqueName string, accessToken string) (SharedToken, error) {
+	token := SharedToken{}
 	req := SharedTokenReq{
 		UniqueName:        uniqueName,
 		AccessToken:       accessToken,
-		ExpiresIn:         0,
+		ExpiresIn:         RevokeExpiresIn,
 		SiteLimit:         "",
 		ShowConversations: true,
 	}
-	return f.GetSharedToken(req)
+	body, err := f.postForm(SharedTokenRegisterPath, req.form())
+	if err != nil {
+		return token, fmt.Errorf("revoke shared token failed: %w", err)
+	}
+	err = json.Unmarshal(body, &token)
+	if err != nil {
+		return token, errors.New("unmarshal response to json failed: " + err.Error())
+	}
+	if token.ExpireAt > f.now().Unix() {
+		return token, fmt.Errorf("revoke shared token failed: %w: %s expires at %d", ErrTokenNotRevoked, token.TokenKey, token.ExpireAt)
+	}
+	return token, nil
 }
 
 type PooledTokenReq struct {
@@ -155,20 +271,18 @@ func (f *AiFakeOpenPlatform) RenewPooledToken(pooledTokenReq PooledTokenReq) (Po
 	formValues.Set("pool_token", pooledTokenReq.PoolToken)
 
 	// Send the form data as a POST request
-	resp, err := f.Client.PostForm(PooledTokenRegisterUrl, formValues)
-	if err != nil {
-		return pToken, errors.New("get pooled token failed: " + err.Error())
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err := f.postForm(PooledTokenRegisterPath, formValues)
 	if err != nil {
-		return pToken, errors.New("read response failed: " + err.Error())
+		return pToken, fmt.Errorf("get pooled token failed: %w", err)
 	}
 
 	err = json.Unmarshal(body, &pToken)
 	if err != nil {
 		return pToken, errors.New("unmarshal response to json failed: " + err.Error())
 	}
+	if pToken.PoolToken == "" {
+		return pToken, fmt.Errorf("get pooled token failed: %w", ErrEmptyToken)
+	}
 	return pToken, nil
 
 }
@@ -190,19 +304,17 @@ func (f *AiFakeOpenPlatform) GetAccessTokenBySessionToken(sessionTokenFromOpenai
 	formValues.Set("session_token", sessionTokenFromOpenai)
 
 	// Send the form data as a POST request
-	resp, err := f.Client.PostForm(SessionTokenGenACTUrl, formValues)
+	body, err := f.postForm(SessionTokenGenACTPath, formValues)
 	if err != nil {
-		return sessionToken, errors.New("get access token failed: " + err.Error())
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return sessionToken, errors.New("read response failed: " + err.Error())
+		return sessionToken, fmt.Errorf("get access token failed: %w", err)
 	}
 
 	err = json.Unmarshal(body, &sessionToken)
 	if err != nil {
 		return sessionToken, errors.New("unmarshal response to json failed: " + err.Error())
 	}
+	if sessionToken.AccessToken == "" {
+		return sessionToken, fmt.Errorf("get access token failed: %w", ErrEmptyToken)
+	}
 	return sessionToken, nil
 }
@@ -1,8 +1,13 @@
 package fakeopen
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestShareToken(t *testing.T) {
@@ -37,3 +42,106 @@ func TestPooledToken(t *testing.T) {
 	}
 	fmt.Println("Pooled token: ", token)
 }
+
+func TestPlatformMirrorFailover(t *testing.T) {
+	var primaryHits, mirrorHits int
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryHits++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer primary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorHits++
+		if r.URL.Path != SharedTokenRegisterPath {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"token_key":"fk-mirror","expire_at":1}`))
+	}))
+	defer mirror.Close()
+
+	platform := NewAiFakeOpenPlatformWithBaseURL(primary.URL+"/", mirror.URL)
+	token, err := platform.GetSharedToken(SharedTokenReq{UniqueName: "a", AccessToken: "b"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token.TokenKey != "fk-mirror" {
+		t.Fatalf("token key = %q, want fk-mirror", token.TokenKey)
+	}
+	if primaryHits != 1 || mirrorHits != 1 {
+		t.Fatalf("hits = %d/%d, want 1/1", primaryHits, mirrorHits)
+	}
+}
+
+func TestPlatformNoFailoverOnClientError(t *testing.T) {
+	var mirrorHits int
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"detail":"bad"}`))
+	}))
+	defer primary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorHits++
+	}))
+	defer mirror.Close()
+
+	platform := NewAiFakeOpenPlatformWithBaseURL(primary.URL, mirror.URL)
+	platform.GetSharedToken(SharedTokenReq{UniqueName: "a", AccessToken: "b"})
+	if mirrorHits != 0 {
+		t.Fatalf("mirror should not be used on 4xx, hits = %d", mirrorHits)
+	}
+}
+
+func TestPlatformNonJSONClientError(t *testing.T) {
+	var mirrorHits int
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<!DOCTYPE html><title>Just a moment...</title>`))
+	}))
+	defer primary.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorHits++
+	}))
+	defer mirror.Close()
+
+	platform := NewAiFakeOpenPlatformWithBaseURL(primary.URL, mirror.URL)
+	_, err := platform.GetSharedToken(SharedTokenReq{UniqueName: "a", AccessToken: "b"})
+	var platformErr *PlatformError
+	if !errors.As(err, &platformErr) || platformErr.Status != http.StatusForbidden {
+		t.Fatalf("err = %v, want *PlatformError with status 403", err)
+	}
+	if errors.Is(err, ErrPlatformUnavailable) {
+		t.Fatalf("non-json 4xx should not match ErrPlatformUnavailable: %v", err)
+	}
+	if mirrorHits != 0 {
+		t.Fatalf("mirror should not be used on non-json 4xx, hits = %d", mirrorHits)
+	}
+}
+
+func TestRevokeSharedTokenVerifiesExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	expireAt := now.Unix()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.FormValue("expires_in"); got != strconv.Itoa(RevokeExpiresIn) {
+			t.Errorf("expires_in = %s, want %d", got, RevokeExpiresIn)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"token_key":"fk-a","unique_name":"%s","expire_at":%d}`, r.FormValue("unique_name"), expireAt)
+	}))
+	defer server.Close()
+	platform := NewAiFakeOpenPlatformWithBaseURL(server.URL)
+	platform.Now = func() time.Time { return now }
+
+	token, err := platform.RevokeSharedToken("name", "at")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token.TokenKey != "fk-a" {
+		t.Fatalf("token key = %q", token.TokenKey)
+	}
+
+	expireAt = now.Add(time.Second).Unix()
+	if _, err := platform.RevokeSharedToken("name", "at"); !errors.Is(err, ErrTokenNotRevoked) {
+		t.Fatalf("err = %v, want ErrTokenNotRevoked", err)
+	}
+}
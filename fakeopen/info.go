@@ -0,0 +1,99 @@
+package fakeopen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SharedTokenInfo fk 的详细信息
+type SharedTokenInfo struct {
+	TokenKey          string `json:"token_key"`
+	UniqueName        string `json:"unique_name"`
+	ExpireAt          int64  `json:"expire_at"`
+	SiteLimit         string `json:"site_limit"`
+	ShowConversations bool   `json:"show_conversations"`
+	ShowUserinfo      bool   `json:"show_userinfo"`
+}
+
+// Expired fk 是否已过期或已撤销
+func (info SharedTokenInfo) Expired(now time.Time) bool {
+	return info.ExpireAt <= now.Unix()
+}
+
+// PooledTokenInfo pk 的详细信息, 平台未返回的字段为零值
+type PooledTokenInfo struct {
+	PoolToken string `json:"pool_token"`
+	Count     int    `json:"count"`
+	//pk 中的fk 列表
+	ShareTokens []string `json:"share_tokens,omitempty"`
+	CreateTime  int64    `json:"create_time,omitempty"`
+	UpdateTime  int64    `json:"update_time,omitempty"`
+	ExpireAt    int64    `json:"expire_at,omitempty"`
+}
+
+// GetSharedTokenInfo
+//
+//	@Description: 查询fk 的过期时间, unique name, site limit 以及显示选项
+//	@receiver f
+//	@param shareToken fk-xxx
+//	@return SharedTokenInfo
+//	@return error fk 不存在时匹配ErrTokenNotFound
+func (f *AiFakeOpenPlatform) GetSharedTokenInfo(shareToken string) (SharedTokenInfo, error) {
+	info := SharedTokenInfo{}
+	if shareToken == "" {
+		return info, errors.New("share token is empty")
+	}
+	body, err := f.get(infoPath(f.SharedTokenInfoPath, SharedTokenInfoPath, shareToken))
+	if err != nil {
+		return info, fmt.Errorf("get shared token info failed: %w", err)
+	}
+	err = json.Unmarshal(body, &info)
+	if err != nil {
+		return info, errors.New("unmarshal response to json failed: " + err.Error())
+	}
+	if info.TokenKey == "" {
+		info.TokenKey = shareToken
+	}
+	return info, nil
+}
+
+// GetPooledTokenInfo
+//
+//	@Description: 查询pk 的成员数量以及成员列表
+//	@receiver f
+//	@param poolToken pk-xxx
+//	@return PooledTokenInfo
+//	@return error pk 不存在时匹配ErrTokenNotFound
+func (f *AiFakeOpenPlatform) GetPooledTokenInfo(poolToken string) (PooledTokenInfo, error) {
+	info := PooledTokenInfo{}
+	if poolToken == "" {
+		return info, errors.New("pool token is empty")
+	}
+	body, err := f.get(infoPath(f.PooledTokenInfoPath, PooledTokenInfoPath, poolToken))
+	if err != nil {
+		return info, fmt.Errorf("get pooled token info failed: %w", err)
+	}
+	err = json.Unmarshal(body, &info)
+	if err != nil {
+		return info, errors.New("unmarshal response to json failed: " + err.Error())
+	}
+	if info.PoolToken == "" {
+		info.PoolToken = poolToken
+	}
+	if info.Count == 0 {
+		info.Count = len(info.ShareTokens)
+	}
+	return info, nil
+}
+
+// infoPath 拼接查询路径 如 /token/info/fk-xxx
+func infoPath(path string, defaultPath string, token string) string {
+	if path == "" {
+		path = defaultPath
+	}
+	return strings.TrimRight(path, "/") + "/" + url.PathEscape(token)
+}
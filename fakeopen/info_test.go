@@ -0,0 +1,56 @@
+package fakeopen
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetSharedTokenInfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/token/info/fk-a":
+			w.Write([]byte(`{"token_key":"fk-a","unique_name":"team","expire_at":4102444800,"site_limit":"https://chat.example.com","show_conversations":true}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"detail":"not found"}`))
+		}
+	}))
+	defer server.Close()
+	platform := NewAiFakeOpenPlatformWithBaseURL(server.URL)
+
+	info, err := platform.GetSharedTokenInfo("fk-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.UniqueName != "team" || info.SiteLimit != "https://chat.example.com" || !info.ShowConversations || info.Expired(time.Now()) {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if _, err := platform.GetSharedTokenInfo("fk-missing"); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("err = %v, want ErrTokenNotFound", err)
+	}
+}
+
+func TestGetPooledTokenInfoCustomPath(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/pool/pk-a" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"pool_token":"pk-a","share_tokens":["fk-a","fk-b"]}`))
+	}))
+	defer server.Close()
+	platform := NewAiFakeOpenPlatformWithBaseURL(server.URL)
+	platform.PooledTokenInfoPath = "/api/pool/"
+
+	info, err := platform.GetPooledTokenInfo("pk-a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Count != 2 || len(info.ShareTokens) != 2 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+}
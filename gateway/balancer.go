@@ -0,0 +1,369 @@
+package gateway
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Member pool 中的一个成员
+type Member struct {
+	//pool 中的标识, fk 或sk key
+	Key string
+	//转发给上游的access token 或sk key
+	Credential string
+	//账号类型 如 plus, free, api, 用于按权重选择
+	Plan string
+}
+
+// KeyMembers
+//
+//	@Description: 将fk/sk 列表(如PooledTokenResult.Members, PooledTokenReq.ShareTokens)转换为成员,
+//	fk 直接作为credential, 适用于上游为ai.fakeopen.com 等接受fk 的服务
+//	@param keys
+//	@return []Member
+func KeyMembers(keys []string) []Member {
+	members := make([]Member, 0, len(keys))
+	for _, key := range keys {
+		member := Member{Key: key, Credential: key}
+		if strings.HasPrefix(key, "sk-") {
+			member.Plan = SkKeyPlan
+		}
+		members = append(members, member)
+	}
+	return members
+}
+
+// Selection 一次选择的上下文
+type Selection struct {
+	//pk 或fk
+	Pool string
+	//sticky 使用的会话标识, 为空时不固定成员
+	Key string
+}
+
+// Strategy 成员选择策略, 返回依次尝试的顺序
+type Strategy interface {
+	Order(sel Selection, members []Member) []Member
+}
+
+// Observer 可选接口, 成员成功处理请求后调用, 用于LRU 和sticky 记录使用情况
+type Observer interface {
+	Used(sel Selection, member Member)
+}
+
+// Forgetter 可选接口, pool 删除或fk 撤销时调用, 用于清理策略中按pool/成员记录的状态
+type Forgetter interface {
+	Forget(key string)
+}
+
+// Balancer 在Strategy 之上跳过最近失败的成员
+type Balancer struct {
+	Strategy Strategy
+	//成员失败后的冷却时间, 冷却中的成员排在最后, 所有成员都在冷却时仍会尝试
+	Cooldown time.Duration
+	//时间来源 默认time.Now
+	Now func() time.Time
+
+	mu      sync.Mutex
+	cooling map[string]time.Time
+}
+
+// NewBalancer
+//
+//	@Description: 创建Balancer, strategy 为空时使用RoundRobin
+//	@param strategy
+//	@param cooldown
+//	@return *Balancer
+func NewBalancer(strategy Strategy, cooldown time.Duration) *Balancer {
+	if strategy == nil {
+		strategy = RoundRobin()
+	}
+	return &Balancer{Strategy: strategy, Cooldown: cooldown, Now: time.Now}
+}
+
+// Order
+//
+//	@Description: 按策略排序, 冷却中的成员移到最后
+//	@receiver b
+//	@param sel
+//	@param members
+//	@return []Member
+func (b *Balancer) Order(sel Selection, members []Member) []Member {
+	ordered := b.Strategy.Order(sel, members)
+	now := b.now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ready := make([]Member, 0, len(ordered))
+	var cooling []Member
+	for _, member := range ordered {
+		if until, ok := b.cooling[member.Key]; ok && now.Before(until) {
+			cooling = append(cooling, member)
+			continue
+		}
+		ready = append(ready, member)
+	}
+	return append(ready, cooling...)
+}
+
+// Success 成员成功处理请求, 结束冷却并通知策略
+func (b *Balancer) Success(sel Selection, member Member) {
+	b.mu.Lock()
+	delete(b.cooling, member.Key)
+	b.mu.Unlock()
+	if observer, ok := b.Strategy.(Observer); ok {
+		observer.Used(sel, member)
+	}
+}
+
+// Failure 成员返回错误, 在Cooldown 内排在最后
+func (b *Balancer) Failure(member Member) {
+	if b.Cooldown <= 0 {
+		return
+	}
+	until := b.now().Add(b.Cooldown)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cooling == nil {
+		b.cooling = make(map[string]time.Time)
+	}
+	b.cooling[member.Key] = until
+}
+
+// Forget
+//
+//	@Description: 清理已删除的pk 或已撤销的fk 的冷却记录及策略状态, Gateway.Proxy 使用的Balancer 会自动调用
+//	@receiver b
+//	@param key pk 或fk
+func (b *Balancer) Forget(key string) {
+	b.mu.Lock()
+	delete(b.cooling, key)
+	b.mu.Unlock()
+	if forgetter, ok := b.Strategy.(Forgetter); ok {
+		forgetter.Forget(key)
+	}
+}
+
+func (b *Balancer) now() time.Time {
+	if b.Now == nil {
+		return time.Now()
+	}
+	return b.Now()
+}
+
+type roundRobin struct {
+	mu   sync.Mutex
+	next map[string]int
+}
+
+// RoundRobin 每个pool 依次从下一个成员开始
+func RoundRobin() Strategy {
+	return &roundRobin{next: make(map[string]int)}
+}
+
+func (s *roundRobin) Order(sel Selection, members []Member) []Member {
+	if len(members) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	start := s.next[sel.Pool] % len(members)
+	s.next[sel.Pool] = start + 1
+	s.mu.Unlock()
+	return append(append([]Member(nil), members[start:]...), members[:start]...)
+}
+
+func (s *roundRobin) Forget(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.next, key)
+}
+
+// lockedRand math/rand.Rand 不是并发安全的
+type lockedRand struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func newLockedRand() *lockedRand {
+	return &lockedRand{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (r *lockedRand) float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rnd.Float64()
+}
+
+func (r *lockedRand) shuffle(n int, swap func(i, j int)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rnd.Shuffle(n, swap)
+}
+
+type random struct {
+	rnd *lockedRand
+}
+
+// Random 随机顺序
+func Random() Strategy {
+	return &random{rnd: newLockedRand()}
+}
+
+func (s *random) Order(sel Selection, members []Member) []Member {
+	ordered := append([]Member(nil), members...)
+	s.rnd.shuffle(len(ordered), func(i, j int) {
+		ordered[i], ordered[j] = ordered[j], ordered[i]
+	})
+	return ordered
+}
+
+type leastRecentlyUsed struct {
+	mu       sync.Mutex
+	sequence uint64
+	lastUsed map[string]uint64
+}
+
+// LeastRecentlyUsed 最久未使用的成员优先, 从未使用的成员按原顺序排在最前
+func LeastRecentlyUsed() Strategy {
+	return &leastRecentlyUsed{lastUsed: make(map[string]uint64)}
+}
+
+func (s *leastRecentlyUsed) Order(sel Selection, members []Member) []Member {
+	ordered := append([]Member(nil), members...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return s.lastUsed[ordered[i].Key] < s.lastUsed[ordered[j].Key]
+	})
+	return ordered
+}
+
+func (s *leastRecentlyUsed) Used(sel Selection, member Member) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sequence++
+	s.lastUsed[member.Key] = s.sequence
+}
+
+func (s *leastRecentlyUsed) Forget(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.lastUsed, key)
+}
+
+type weighted struct {
+	weights       map[string]int
+	defaultWeight int
+	rnd           *lockedRand
+}
+
+// Weighted
+//
+//	@Description: 按账号类型的权重随机排序, 权重越大越靠前
+//	@param weights 如 {"plus": 5, "free": 1, "api": 3}
+//	@param defaultWeight 未配置的类型使用的权重, 权重<=0 的成员排在最后
+//	@return Strategy
+func Weighted(weights map[string]int, defaultWeight int) Strategy {
+	return &weighted{weights: weights, defaultWeight: defaultWeight, rnd: newLockedRand()}
+}
+
+func (s *weighted) Order(sel Selection, members []Member) []Member {
+	type scored struct {
+		member Member
+		score  float64
+	}
+	scores := make([]scored, 0, len(members))
+	for _, member := range members {
+		weight, ok := s.weights[member.Plan]
+		if !ok {
+			weight = s.defaultWeight
+		}
+		score := math.Inf(-1)
+		if weight > 0 {
+			//加权随机排序: u^(1/w) 越大越靠前
+			score = math.Pow(s.rnd.float64(), 1/float64(weight))
+		}
+		scores = append(scores, scored{member: member, score: score})
+	}
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].score > scores[j].score
+	})
+	ordered := make([]Member, 0, len(scores))
+	for _, s := range scores {
+		ordered = append(ordered, s.member)
+	}
+	return ordered
+}
+
+// stickyLimit 记录的会话数上限, 超过后清空重新记录, pool 删除或fk 撤销时通过Forget 清理对应的绑定
+const stickyLimit = 100000
+
+type sticky struct {
+	fallback Strategy
+	mu       sync.Mutex
+	bindings map[string]string
+}
+
+// Sticky
+//
+//	@Description: 同一会话固定使用上次成功的成员, 使多轮对话保持在同一个账号
+//	Selection.Key 为空或绑定的成员不在pool 中时使用fallback
+//	@param fallback 为空时使用RoundRobin
+//	@return Strategy
+func Sticky(fallback Strategy) Strategy {
+	if fallback == nil {
+		fallback = RoundRobin()
+	}
+	return &sticky{fallback: fallback, bindings: make(map[string]string)}
+}
+
+func (s *sticky) Order(sel Selection, members []Member) []Member {
+	ordered := s.fallback.Order(sel, members)
+	if sel.Key == "" {
+		return ordered
+	}
+	s.mu.Lock()
+	bound, ok := s.bindings[sel.Pool+"\n"+sel.Key]
+	s.mu.Unlock()
+	if !ok {
+		return ordered
+	}
+	for i, member := range ordered {
+		if member.Key == bound {
+			result := append([]Member{member}, ordered[:i]...)
+			return append(result, ordered[i+1:]...)
+		}
+	}
+	return ordered
+}
+
+func (s *sticky) Used(sel Selection, member Member) {
+	if sel.Key != "" {
+		s.mu.Lock()
+		if len(s.bindings) >= stickyLimit {
+			s.bindings = make(map[string]string)
+		}
+		s.bindings[sel.Pool+"\n"+sel.Key] = member.Key
+		s.mu.Unlock()
+	}
+	if observer, ok := s.fallback.(Observer); ok {
+		observer.Used(sel, member)
+	}
+}
+
+// Forget 删除属于key 这个pool 或绑定到key 这个成员的会话
+func (s *sticky) Forget(key string) {
+	s.mu.Lock()
+	for binding, member := range s.bindings {
+		if member == key || strings.HasPrefix(binding, key+"\n") {
+			delete(s.bindings, binding)
+		}
+	}
+	s.mu.Unlock()
+	if forgetter, ok := s.fallback.(Forgetter); ok {
+		forgetter.Forget(key)
+	}
+}
@@ -0,0 +1,111 @@
+package gateway
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func memberKeys(members []Member) string {
+	keys := make([]string, 0, len(members))
+	for _, member := range members {
+		keys = append(keys, member.Key)
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestBalancerRoundRobinCooldown(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := NewBalancer(RoundRobin(), time.Minute)
+	b.Now = func() time.Time { return now }
+	members := KeyMembers([]string{"fk-a", "fk-b", "fk-c"})
+	sel := Selection{Pool: "pk-a"}
+
+	if got := memberKeys(b.Order(sel, members)); got != "fk-a,fk-b,fk-c" {
+		t.Fatalf("first order = %s", got)
+	}
+	if got := memberKeys(b.Order(sel, members)); got != "fk-b,fk-c,fk-a" {
+		t.Fatalf("second order = %s", got)
+	}
+	b.Failure(members[2])
+	if got := memberKeys(b.Order(sel, members)); got != "fk-a,fk-b,fk-c" {
+		t.Fatalf("cooling member should be last, got %s", got)
+	}
+	now = now.Add(2 * time.Minute)
+	if got := memberKeys(b.Order(sel, members)); got != "fk-a,fk-b,fk-c" {
+		t.Fatalf("cooldown should expire, got %s", got)
+	}
+}
+
+func TestBalancerLeastRecentlyUsed(t *testing.T) {
+	b := NewBalancer(LeastRecentlyUsed(), time.Minute)
+	members := KeyMembers([]string{"fk-a", "fk-b", "fk-c"})
+	sel := Selection{Pool: "pk-a"}
+	b.Success(sel, members[0])
+	b.Success(sel, members[2])
+	if got := memberKeys(b.Order(sel, members)); got != "fk-b,fk-a,fk-c" {
+		t.Fatalf("order = %s", got)
+	}
+}
+
+func TestBalancerWeighted(t *testing.T) {
+	strategy := Weighted(map[string]int{"plus": 100, "free": 0}, 1)
+	members := []Member{{Key: "fk-free", Plan: "free"}, {Key: "fk-other"}, {Key: "fk-plus", Plan: "plus"}}
+	plusFirst := 0
+	for i := 0; i < 200; i++ {
+		ordered := strategy.Order(Selection{}, members)
+		if ordered[2].Key != "fk-free" {
+			t.Fatalf("zero weight member should be last, got %s", memberKeys(ordered))
+		}
+		if ordered[0].Key == "fk-plus" {
+			plusFirst++
+		}
+	}
+	if plusFirst < 180 {
+		t.Fatalf("plus member first %d/200 times", plusFirst)
+	}
+}
+
+func TestBalancerSticky(t *testing.T) {
+	b := NewBalancer(Sticky(RoundRobin()), time.Minute)
+	members := KeyMembers([]string{"fk-a", "fk-b", "fk-c"})
+	chat := Selection{Pool: "pk-a", Key: "conversation-1"}
+
+	first := b.Order(chat, members)[0]
+	b.Success(chat, first)
+	for i := 0; i < 3; i++ {
+		if got := b.Order(chat, members)[0]; got.Key != first.Key {
+			t.Fatalf("sticky member = %s, want %s", got.Key, first.Key)
+		}
+	}
+	b.Failure(first)
+	next := b.Order(chat, members)[0]
+	if next.Key == first.Key {
+		t.Fatal("cooling sticky member should not be first")
+	}
+	b.Success(chat, next)
+	if got := b.Order(chat, members)[0]; got.Key != next.Key {
+		t.Fatalf("sticky should move to %s, got %s", next.Key, got.Key)
+	}
+}
+
+func TestBalancerForget(t *testing.T) {
+	rr := RoundRobin().(*roundRobin)
+	stick := Sticky(rr).(*sticky)
+	b := NewBalancer(stick, time.Minute)
+	members := KeyMembers([]string{"fk-a", "fk-b"})
+	chat := Selection{Pool: "pk-a", Key: "conversation-1"}
+	other := Selection{Pool: "pk-b", Key: "conversation-2"}
+	b.Success(chat, b.Order(chat, members)[0])
+	b.Success(other, members[1])
+	b.Failure(members[1])
+
+	b.Forget("pk-a")
+	if len(stick.bindings) != 1 || len(rr.next) != 0 {
+		t.Fatalf("pool state should be dropped: %v %v", stick.bindings, rr.next)
+	}
+	b.Forget("fk-b")
+	if len(stick.bindings) != 0 || len(b.cooling) != 0 {
+		t.Fatalf("member state should be dropped: %v %v", stick.bindings, b.cooling)
+	}
+}
@@ -0,0 +1,478 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fireinrain/opaitokens/fakeopen"
+	"github.com/fireinrain/opaitokens/internal/transport"
+	"github.com/fireinrain/opaitokens/utils"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 自建的fk/pk 签发服务, 与ai.fakeopen.com 的/token/register 和/pool/update 语义一致
+// fk 对应一个上游access token, pk 对应一组fk(或sk key)
+
+var (
+	// ErrInvalidAccessToken access token 不是有效的jwt 或已过期
+	ErrInvalidAccessToken = errors.New("invalid access token")
+	// ErrTokenNotFound fk/pk 不存在
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrTokenExpired fk 已过期或已撤销
+	ErrTokenExpired = errors.New("token expired")
+	// ErrVerifyUnavailable 上游校验access token 失败, 可以稍后重试
+	ErrVerifyUnavailable = errors.New("verify access token failed")
+)
+
+// DefaultVerifyURL 默认校验access token 的上游地址
+const DefaultVerifyURL = "https://chat.openai.com/backend-api/me"
+
+// ShareToken 已签发的fk
+type ShareToken struct {
+	TokenKey          string `json:"token_key"`
+	UniqueName        string `json:"unique_name"`
+	ExpireAt          int64  `json:"expire_at"`
+	SiteLimit         string `json:"site_limit"`
+	ShowConversations bool   `json:"show_conversations"`
+	ShowUserinfo      bool   `json:"show_userinfo"`
+	//access token 中的sub, 同一账号同一unique_name 续期时fk 保持不变
+	Subject     string `json:"subject"`
+	AccessToken string `json:"access_token"`
+	//账号类型, 用于Weighted 策略
+	Plan string `json:"plan,omitempty"`
+}
+
+// PoolToken 已签发的pk
+type PoolToken struct {
+	PoolToken   string   `json:"pool_token"`
+	ShareTokens []string `json:"share_tokens"`
+	CreateTime  int64    `json:"create_time"`
+	UpdateTime  int64    `json:"update_time"`
+}
+
+// Options 网关配置
+type Options struct {
+	//保存fk/pk 的文件, 包含access token, 以0600 权限写入, 为空时仅保存在内存
+	StatePath string
+	//时间来源 默认time.Now
+	Now func() time.Time
+	//签发fk 前向上游校验access token, 返回nil 表示有效 默认使用UpstreamVerifier 请求DefaultVerifyURL
+	//jwt 中的sub 只有在上游确认后才可信, 否则任何人都可以伪造他人的sub 取得或撤销其fk
+	Verify func(accessToken string) error
+	//Handler 中/token/register, /pool/update, /pool/info 要求的 Authorization: Bearer 令牌, 为空时拒绝这些请求
+	AdminToken string
+}
+
+type Gateway struct {
+	opts   Options
+	mu     sync.RWMutex
+	shares map[string]*ShareToken
+	//subject + unique_name -> fk
+	shareIndex map[string]string
+	pools      map[string]*PoolToken
+	//Proxy 使用的Balancer, pk 删除或fk 撤销时清理其状态
+	forgetters []Forgetter
+}
+
+// New
+//
+//	@Description: 创建网关, StatePath 对应文件存在时加载已签发的token
+//	@param opts
+//	@return *Gateway
+//	@return error
+func New(opts Options) (*Gateway, error) {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Verify == nil {
+		opts.Verify = UpstreamVerifier(nil, DefaultVerifyURL)
+	}
+	g := &Gateway{
+		opts:       opts,
+		shares:     make(map[string]*ShareToken),
+		shareIndex: make(map[string]string),
+		pools:      make(map[string]*PoolToken),
+	}
+	if opts.StatePath != "" {
+		if err := g.load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// UpstreamVerifier
+//
+//	@Description: 使用access token 请求verifyURL, 2xx 表示有效, 401/403 表示无效
+//	@param client 为空时使用默认client
+//	@param verifyURL 如 DefaultVerifyURL
+//	@return func(accessToken string) error
+func UpstreamVerifier(client *http.Client, verifyURL string) func(accessToken string) error {
+	if client == nil {
+		client = transport.Default()
+	}
+	return func(accessToken string) error {
+		req, err := http.NewRequest(http.MethodGet, verifyURL, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrVerifyUnavailable, err)
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+			return ErrInvalidAccessToken
+		}
+		return fmt.Errorf("%w: %s", ErrVerifyUnavailable, resp.Status)
+	}
+}
+
+// RegisterShareToken
+//
+//	@Description: 注册或续期fk, expires_in 为0时使用access token 的过期时间, 为负数时撤销, 撤销不存在的fk 返回ErrTokenNotFound
+//	access token 需要先通过Options.Verify 的上游校验, 只有持有同一账号有效access token 的请求才能取得或修改已有的fk
+//	@receiver g
+//	@param req
+//	@return fakeopen.SharedToken
+//	@return error
+func (g *Gateway) RegisterShareToken(req fakeopen.SharedTokenReq) (fakeopen.SharedToken, error) {
+	if req.UniqueName == "" {
+		return fakeopen.SharedToken{}, &RequestError{Status: http.StatusBadRequest, Detail: "unique_name is required"}
+	}
+	if req.SiteLimit != "" {
+		u, err := url.Parse(req.SiteLimit)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fakeopen.SharedToken{}, &RequestError{Status: http.StatusBadRequest, Detail: "invalid site_limit"}
+		}
+	}
+	var claims struct {
+		Sub  string `json:"sub"`
+		Exp  int64  `json:"exp"`
+		Auth struct {
+			PlanType string `json:"chatgpt_plan_type"`
+		} `json:"https://api.openai.com/auth"`
+	}
+	now := g.opts.Now().Unix()
+	if err := utils.JwtClaims(req.AccessToken, &claims); err != nil || claims.Sub == "" || claims.Exp <= now {
+		return fakeopen.SharedToken{}, ErrInvalidAccessToken
+	}
+	//sub 只是未校验签名的jwt 内容, 上游确认token 有效后才能用来查找已有的fk
+	if err := g.opts.Verify(req.AccessToken); err != nil {
+		if errors.Is(err, ErrInvalidAccessToken) || errors.Is(err, ErrVerifyUnavailable) {
+			return fakeopen.SharedToken{}, err
+		}
+		return fakeopen.SharedToken{}, fmt.Errorf("%w: %v", ErrInvalidAccessToken, err)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	index := claims.Sub + "\n" + req.UniqueName
+	share, ok := g.shares[g.shareIndex[index]]
+	if !ok && req.ExpiresIn < 0 {
+		//撤销不存在的fk 时不创建记录
+		return fakeopen.SharedToken{}, ErrTokenNotFound
+	}
+	if !ok {
+		share = &ShareToken{TokenKey: randomToken("fk-"), UniqueName: req.UniqueName, Subject: claims.Sub}
+		g.shares[share.TokenKey] = share
+		g.shareIndex[index] = share.TokenKey
+	}
+	if share.Plan == "" {
+		share.Plan = claims.Auth.PlanType
+	}
+	share.SiteLimit = strings.TrimRight(req.SiteLimit, "/")
+	share.ShowConversations = req.ShowConversations
+	share.ShowUserinfo = req.ShowUserinfo
+	switch {
+	case req.ExpiresIn < 0:
+		//撤销时不再保留access token
+		share.ExpireAt = 0
+		share.AccessToken = ""
+		g.forget(share.TokenKey)
+	case req.ExpiresIn == 0 || now+int64(req.ExpiresIn) > claims.Exp:
+		share.ExpireAt = claims.Exp
+		share.AccessToken = req.AccessToken
+	default:
+		share.ExpireAt = now + int64(req.ExpiresIn)
+		share.AccessToken = req.AccessToken
+	}
+	if err := g.save(); err != nil {
+		return fakeopen.SharedToken{}, err
+	}
+	return share.sharedToken(), nil
+}
+
+// UpdatePool
+//
+//	@Description: 创建或更新pk, pool_token 为空时创建新的pk, share_tokens 为空时删除pk
+//	@receiver g
+//	@param req
+//	@return fakeopen.PooledToken
+//	@return error
+func (g *Gateway) UpdatePool(req fakeopen.PooledTokenReq) (fakeopen.PooledToken, error) {
+	members := make([]string, 0, len(req.ShareTokens))
+	seen := make(map[string]bool)
+	for _, token := range req.ShareTokens {
+		token = strings.TrimSpace(token)
+		if token == "" || seen[token] {
+			continue
+		}
+		seen[token] = true
+		members = append(members, token)
+	}
+	if len(members) > fakeopen.PooledTokensLimit {
+		return fakeopen.PooledToken{}, &RequestError{Status: http.StatusBadRequest, Detail: "too many share tokens"}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, member := range members {
+		if _, ok := g.shares[member]; !ok && !strings.HasPrefix(member, "sk-") {
+			return fakeopen.PooledToken{}, &RequestError{Status: http.StatusBadRequest, Detail: "invalid share token: " + maskToken(member)}
+		}
+	}
+	now := g.opts.Now().Unix()
+	var pool *PoolToken
+	if req.PoolToken != "" {
+		existing, ok := g.pools[req.PoolToken]
+		if !ok {
+			return fakeopen.PooledToken{}, ErrTokenNotFound
+		}
+		pool = existing
+	}
+	if len(members) == 0 {
+		if pool == nil {
+			return fakeopen.PooledToken{}, &RequestError{Status: http.StatusBadRequest, Detail: "share_tokens is required"}
+		}
+		delete(g.pools, pool.PoolToken)
+		g.forget(pool.PoolToken)
+		if err := g.save(); err != nil {
+			return fakeopen.PooledToken{}, err
+		}
+		return fakeopen.PooledToken{PoolToken: pool.PoolToken}, nil
+	}
+	if pool == nil {
+		pool = &PoolToken{PoolToken: randomToken("pk-"), CreateTime: now}
+		g.pools[pool.PoolToken] = pool
+	}
+	pool.ShareTokens = members
+	pool.UpdateTime = now
+	if err := g.save(); err != nil {
+		return fakeopen.PooledToken{}, err
+	}
+	return fakeopen.PooledToken{Count: len(members), PoolToken: pool.PoolToken}, nil
+}
+
+// SetPlan
+//
+//	@Description: 设置fk 对应的账号类型, 用于Weighted 策略
+//	@receiver g
+//	@param fk
+//	@param plan 如 plus, free
+//	@return error
+func (g *Gateway) SetPlan(fk string, plan string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	share, ok := g.shares[fk]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	share.Plan = plan
+	return g.save()
+}
+
+// ShareTokenInfo
+//
+//	@Description: 查询fk, 不包含access token
+//	@receiver g
+//	@param fk
+//	@return fakeopen.SharedTokenInfo
+//	@return error
+func (g *Gateway) ShareTokenInfo(fk string) (fakeopen.SharedTokenInfo, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	share, ok := g.shares[fk]
+	if !ok {
+		return fakeopen.SharedTokenInfo{}, ErrTokenNotFound
+	}
+	return fakeopen.SharedTokenInfo{
+		TokenKey:          share.TokenKey,
+		UniqueName:        share.UniqueName,
+		ExpireAt:          share.ExpireAt,
+		SiteLimit:         share.SiteLimit,
+		ShowConversations: share.ShowConversations,
+		ShowUserinfo:      share.ShowUserinfo,
+	}, nil
+}
+
+// PoolInfo
+//
+//	@Description: 查询pk 的成员
+//	@receiver g
+//	@param pk
+//	@return fakeopen.PooledTokenInfo
+//	@return error
+func (g *Gateway) PoolInfo(pk string) (fakeopen.PooledTokenInfo, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	pool, ok := g.pools[pk]
+	if !ok {
+		return fakeopen.PooledTokenInfo{}, ErrTokenNotFound
+	}
+	return fakeopen.PooledTokenInfo{
+		PoolToken:   pool.PoolToken,
+		Count:       len(pool.ShareTokens),
+		ShareTokens: append([]string(nil), pool.ShareTokens...),
+		CreateTime:  pool.CreateTime,
+		UpdateTime:  pool.UpdateTime,
+	}, nil
+}
+
+// AccessToken
+//
+//	@Description: 将fk 解析为上游access token
+//	@receiver g
+//	@param fk
+//	@return string
+//	@return error fk 不存在时为ErrTokenNotFound, 过期或已撤销时为ErrTokenExpired
+func (g *Gateway) AccessToken(fk string) (string, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	share, ok := g.shares[fk]
+	if !ok {
+		return "", ErrTokenNotFound
+	}
+	if share.AccessToken == "" || share.ExpireAt <= g.opts.Now().Unix() {
+		return "", ErrTokenExpired
+	}
+	return share.AccessToken, nil
+}
+
+// member 将fk 解析为Member
+func (g *Gateway) member(fk string) (Member, error) {
+	accessToken, err := g.AccessToken(fk)
+	if err != nil {
+		return Member{}, err
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return Member{Key: fk, Credential: accessToken, Plan: g.shares[fk].Plan}, nil
+}
+
+// PoolMembers
+//
+//	@Description: 将pk 解析为其中的fk 以及sk key
+//	@receiver g
+//	@param pk
+//	@return []string
+//	@return error
+func (g *Gateway) PoolMembers(pk string) ([]string, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	pool, ok := g.pools[pk]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	return append([]string(nil), pool.ShareTokens...), nil
+}
+
+// forget 通知Balancer 清理key 的状态, 调用方需持有写锁
+func (g *Gateway) forget(key string) {
+	for _, forgetter := range g.forgetters {
+		forgetter.Forget(key)
+	}
+}
+
+func (share *ShareToken) sharedToken() fakeopen.SharedToken {
+	return fakeopen.SharedToken{
+		ExpireAt:          share.ExpireAt,
+		ShowConversations: share.ShowConversations,
+		ShowUserinfo:      share.ShowUserinfo,
+		SiteLimit:         share.SiteLimit,
+		TokenKey:          share.TokenKey,
+		UniqueName:        share.UniqueName,
+	}
+}
+
+type state struct {
+	ShareTokens []ShareToken `json:"share_tokens"`
+	PoolTokens  []PoolToken  `json:"pool_tokens"`
+}
+
+func (g *Gateway) load() error {
+	data, err := os.ReadFile(g.opts.StatePath)
+	if err != nil {
+		return err
+	}
+	st := state{}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return errors.New("unmarshal gateway state failed: " + err.Error())
+	}
+	for _, share := range st.ShareTokens {
+		share := share
+		g.shares[share.TokenKey] = &share
+		g.shareIndex[share.Subject+"\n"+share.UniqueName] = share.TokenKey
+	}
+	for _, pool := range st.PoolTokens {
+		pool := pool
+		g.pools[pool.PoolToken] = &pool
+	}
+	return nil
+}
+
+// save 调用方需持有写锁
+func (g *Gateway) save() error {
+	if g.opts.StatePath == "" {
+		return nil
+	}
+	st := state{}
+	for _, share := range g.shares {
+		st.ShareTokens = append(st.ShareTokens, *share)
+	}
+	for _, pool := range g.pools {
+		st.PoolTokens = append(st.PoolTokens, *pool)
+	}
+	sort.Slice(st.ShareTokens, func(i, j int) bool { return st.ShareTokens[i].TokenKey < st.ShareTokens[j].TokenKey })
+	sort.Slice(st.PoolTokens, func(i, j int) bool { return st.PoolTokens[i].PoolToken < st.PoolTokens[j].PoolToken })
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = utils.WriteFileAtomic(g.opts.StatePath, data, 0600)
+	if err != nil {
+		return errors.New("save gateway state failed: " + err.Error())
+	}
+	return nil
+}
+
+// randomToken 生成prefix 开头的随机token
+func randomToken(prefix string) string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+// maskToken 错误信息中只保留token 的前几位
+func maskToken(token string) string {
+	if len(token) <= 8 {
+		return token
+	}
+	return token[:8] + "..."
+}
@@ -0,0 +1,177 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/fireinrain/opaitokens/fakeopen"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAccessToken(sub string, exp int64) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"sub":%q,"exp":%d}`, sub, exp)))
+	return header + "." + payload + ".sig"
+}
+
+// acceptAll 测试中跳过上游校验
+func acceptAll(string) error {
+	return nil
+}
+
+func TestGatewayWithFakeOpenPlatform(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	g, err := New(Options{Now: func() time.Time { return now }, Verify: acceptAll, AdminToken: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(g.Handler())
+	defer server.Close()
+	platform := fakeopen.NewAiFakeOpenPlatformWithBaseURL(server.URL)
+	platform.Client = AdminClient("admin")
+
+	at := testAccessToken("user-a", now.Unix()+3600)
+	share, err := platform.GetSharedToken(fakeopen.SharedTokenReq{UniqueName: "team", AccessToken: at, ExpiresIn: 600, ShowConversations: true})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if share.ExpireAt != now.Unix()+600 || share.UniqueName != "team" {
+		t.Fatalf("unexpected share token %+v", share)
+	}
+	renewed, err := platform.GetSharedToken(fakeopen.SharedTokenReq{UniqueName: "team", AccessToken: at})
+	if err != nil || renewed.TokenKey != share.TokenKey || renewed.ExpireAt != now.Unix()+3600 {
+		t.Fatalf("renew should keep the fk and follow access token expiry: %+v %v", renewed, err)
+	}
+	if got, err := g.AccessToken(share.TokenKey); err != nil || got != at {
+		t.Fatalf("access token = %q, %v", got, err)
+	}
+
+	pool, err := platform.RenewPooledToken(fakeopen.PooledTokenReq{ShareTokens: []string{share.TokenKey, "sk-offline"}})
+	if err != nil || pool.Count != 2 {
+		t.Fatalf("create pool: %+v %v", pool, err)
+	}
+	info, err := platform.GetPooledTokenInfo(pool.PoolToken)
+	if err != nil || len(info.ShareTokens) != 2 {
+		t.Fatalf("pool info: %+v %v", info, err)
+	}
+	if _, err := platform.RenewPooledToken(fakeopen.PooledTokenReq{ShareTokens: []string{"fk-unknown"}, PoolToken: pool.PoolToken}); err == nil {
+		t.Fatal("unknown fk should be rejected")
+	}
+
+	if _, err := platform.RevokeSharedToken("team", at); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, err := platform.RevokeSharedToken("unknown", at); !errors.Is(err, fakeopen.ErrTokenNotFound) || len(g.shares) != 1 {
+		t.Fatalf("revoking an unknown name should not create a share token: %v %d", err, len(g.shares))
+	}
+	if _, err := g.AccessToken(share.TokenKey); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("revoked fk err = %v", err)
+	}
+
+	if _, err := platform.GetSharedToken(fakeopen.SharedTokenReq{UniqueName: "team", AccessToken: "not-a-jwt"}); !errors.Is(err, fakeopen.ErrInvalidAccessToken) {
+		t.Fatalf("invalid access token err = %v", err)
+	}
+}
+
+func TestGatewayState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateway.json")
+	g, err := New(Options{StatePath: path, Verify: acceptAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	share, err := g.RegisterShareToken(fakeopen.SharedTokenReq{UniqueName: "team", AccessToken: testAccessToken("user-a", time.Now().Unix()+3600)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err := g.UpdatePool(fakeopen.PooledTokenReq{ShareTokens: []string{share.TokenKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := New(Options{StatePath: path, Verify: acceptAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	members, err := reopened.PoolMembers(pool.PoolToken)
+	if err != nil || len(members) != 1 || members[0] != share.TokenKey {
+		t.Fatalf("members = %v, %v", members, err)
+	}
+	if _, err := reopened.AccessToken(share.TokenKey); err != nil {
+		t.Fatalf("access token: %v", err)
+	}
+}
+
+func TestGatewayRejectsForgedAccessToken(t *testing.T) {
+	exp := time.Now().Unix() + 3600
+	victim := testAccessToken("user-a", exp)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+victim {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer upstream.Close()
+	g, err := New(Options{Verify: UpstreamVerifier(nil, upstream.URL), AdminToken: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	share, err := g.RegisterShareToken(fakeopen.SharedTokenReq{UniqueName: "team", AccessToken: victim})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	//伪造的jwt 与victim 的sub 相同, 不能取得或撤销其fk
+	forged := testAccessToken("user-a", exp+1)
+	if token, err := g.RegisterShareToken(fakeopen.SharedTokenReq{UniqueName: "team", AccessToken: forged, ExpiresIn: -1}); !errors.Is(err, ErrInvalidAccessToken) || token.TokenKey != "" {
+		t.Fatalf("forged token should be rejected: %+v %v", token, err)
+	}
+	if got, err := g.AccessToken(share.TokenKey); err != nil || got != victim {
+		t.Fatalf("victim fk changed: %q %v", got, err)
+	}
+
+	server := httptest.NewServer(g.Handler())
+	defer server.Close()
+	for _, auth := range []string{"", "Bearer wrong"} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+fakeopen.PooledTokenRegisterPath, strings.NewReader("share_tokens="+share.TokenKey))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("pool update with %q: %s", auth, resp.Status)
+		}
+	}
+}
+
+func TestGatewayForgetsRemovedTokens(t *testing.T) {
+	g, _ := New(Options{Verify: acceptAll})
+	balancer := NewBalancer(RoundRobin(), time.Minute)
+	g.Proxy(ProxyOptions{Balancer: balancer})
+	rr := balancer.Strategy.(*roundRobin)
+
+	at := testAccessToken("user-a", time.Now().Unix()+3600)
+	share, _ := g.RegisterShareToken(fakeopen.SharedTokenReq{UniqueName: "team", AccessToken: at})
+	pool, _ := g.UpdatePool(fakeopen.PooledTokenReq{ShareTokens: []string{share.TokenKey}})
+	members := KeyMembers([]string{share.TokenKey})
+	balancer.Order(Selection{Pool: pool.PoolToken}, members)
+	balancer.Order(Selection{Pool: share.TokenKey}, members)
+
+	if _, err := g.UpdatePool(fakeopen.PooledTokenReq{PoolToken: pool.PoolToken}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.RegisterShareToken(fakeopen.SharedTokenReq{UniqueName: "team", AccessToken: at, ExpiresIn: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if len(rr.next) != 0 {
+		t.Fatalf("removed tokens should be forgotten: %v", rr.next)
+	}
+}
@@ -0,0 +1,188 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"github.com/fireinrain/opaitokens/fakeopen"
+	"github.com/fireinrain/opaitokens/internal/transport"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RequestError 请求参数错误, 以{"detail": "..."} 返回
+type RequestError struct {
+	Status int
+	Detail string
+}
+
+func (e *RequestError) Error() string {
+	return e.Detail
+}
+
+// Handler
+//
+//	@Description: 与fakeopen 相同的表单接口, fakeopen.AiFakeOpenPlatform 的BaseURL 指向网关即可使用
+//	POST /token/register, POST /pool/update, GET /token/info/{fk}, GET /pool/info/{pk}
+//	除/token/info 外都需要 Authorization: Bearer Options.AdminToken, 可以通过platform client 的默认请求头设置
+//	@receiver g
+//	@return http.Handler
+func (g *Gateway) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(fakeopen.SharedTokenRegisterPath, g.requireAdmin(g.handleRegister))
+	mux.HandleFunc(fakeopen.PooledTokenRegisterPath, g.requireAdmin(g.handlePoolUpdate))
+	mux.HandleFunc(fakeopen.SharedTokenInfoPath+"/", g.handleShareInfo)
+	//pool 信息中包含成员fk, 同样只允许管理员查询
+	mux.HandleFunc(fakeopen.PooledTokenInfoPath+"/", g.requireAdmin(g.handlePoolInfo))
+	return mux
+}
+
+// AdminClient
+//
+//	@Description: 请求时带上 Authorization: Bearer adminToken 的client, 用于指向网关的fakeopen.AiFakeOpenPlatform
+//	不要作为WithHTTPClient 使用, 否则登录请求也会带上管理令牌
+//	@param adminToken
+//	@return *http.Client
+func AdminClient(adminToken string) *http.Client {
+	return transport.MustNewClient(transport.Config{Headers: http.Header{"Authorization": []string{"Bearer " + adminToken}}})
+}
+
+// requireAdmin 校验管理令牌, 未配置Options.AdminToken 时拒绝所有请求
+func (g *Gateway) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if g.opts.AdminToken == "" {
+			writeError(w, &RequestError{Status: http.StatusForbidden, Detail: "admin token is not configured"})
+			return
+		}
+		token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if subtle.ConstantTimeCompare([]byte(token), []byte(g.opts.AdminToken)) != 1 {
+			writeError(w, &RequestError{Status: http.StatusUnauthorized, Detail: "invalid admin token"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (g *Gateway) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, &RequestError{Status: http.StatusMethodNotAllowed, Detail: "method not allowed"})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, &RequestError{Status: http.StatusBadRequest, Detail: "invalid form: " + err.Error()})
+		return
+	}
+	req := fakeopen.SharedTokenReq{
+		UniqueName:  strings.TrimSpace(r.PostForm.Get("unique_name")),
+		AccessToken: strings.TrimSpace(r.PostForm.Get("access_token")),
+		SiteLimit:   strings.TrimSpace(r.PostForm.Get("site_limit")),
+	}
+	var err error
+	if req.ExpiresIn, err = formInt(r, "expires_in"); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.ShowConversations, err = formBool(r, "show_conversations", true); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.ShowUserinfo, err = formBool(r, "show_userinfo", false); err != nil {
+		writeError(w, err)
+		return
+	}
+	token, err := g.RegisterShareToken(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, token)
+}
+
+func (g *Gateway) handlePoolUpdate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, &RequestError{Status: http.StatusMethodNotAllowed, Detail: "method not allowed"})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, &RequestError{Status: http.StatusBadRequest, Detail: "invalid form: " + err.Error()})
+		return
+	}
+	req := fakeopen.PooledTokenReq{
+		ShareTokens: strings.Split(r.PostForm.Get("share_tokens"), "\n"),
+		PoolToken:   strings.TrimSpace(r.PostForm.Get("pool_token")),
+	}
+	token, err := g.UpdatePool(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, token)
+}
+
+func (g *Gateway) handleShareInfo(w http.ResponseWriter, r *http.Request) {
+	info, err := g.ShareTokenInfo(strings.TrimPrefix(r.URL.Path, fakeopen.SharedTokenInfoPath+"/"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (g *Gateway) handlePoolInfo(w http.ResponseWriter, r *http.Request) {
+	info, err := g.PoolInfo(strings.TrimPrefix(r.URL.Path, fakeopen.PooledTokenInfoPath+"/"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func formInt(r *http.Request, key string) (int, error) {
+	value := strings.TrimSpace(r.PostForm.Get(key))
+	if value == "" {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, &RequestError{Status: http.StatusUnprocessableEntity, Detail: "invalid " + key}
+	}
+	return i, nil
+}
+
+func formBool(r *http.Request, key string, defaultValue bool) (bool, error) {
+	value := strings.TrimSpace(r.PostForm.Get(key))
+	if value == "" {
+		return defaultValue, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, &RequestError{Status: http.StatusUnprocessableEntity, Detail: "invalid " + key}
+	}
+	return b, nil
+}
+
+// writeError 将错误转换为fakeopen 风格的{"detail": "..."} 响应
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var reqErr *RequestError
+	switch {
+	case errors.As(err, &reqErr):
+		status = reqErr.Status
+	case errors.Is(err, ErrInvalidAccessToken):
+		status = http.StatusUnauthorized
+	case errors.Is(err, ErrVerifyUnavailable):
+		status = http.StatusBadGateway
+	case errors.Is(err, ErrTokenNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrTokenExpired):
+		status = http.StatusUnauthorized
+	}
+	writeJSON(w, status, map[string]string{"detail": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,329 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/fireinrain/opaitokens/internal/transport"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultUpstreamURL 默认的上游地址
+const DefaultUpstreamURL = "https://api.openai.com"
+
+// DefaultStickyHeader 默认的会话标识请求头
+const DefaultStickyHeader = "X-Conversation-Id"
+
+// SkKeyPlan sk key 成员的账号类型
+const SkKeyPlan = "api"
+
+// ErrNoAvailableMember pool 中没有可用的成员
+var ErrNoAvailableMember = errors.New("no available member")
+
+// ErrSiteNotAllowed 请求的Origin/Referer 与fk 的site_limit 不一致
+var ErrSiteNotAllowed = errors.New("origin not allowed by site_limit")
+
+// ErrConversationsHidden show_conversations 为false 的fk 不能读取历史会话
+var ErrConversationsHidden = errors.New("conversations are hidden for this share token")
+
+// conversationPaths 保存历史会话的接口, show_conversations 为false 时只允许POST 创建, 不允许列出/读取/删除
+var conversationPaths = []string{"/v1/conversations", "/v1/threads", "/v1/responses", "/v1/chat/completions"}
+
+// hopHeaders 不转发的逐跳请求头
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// clientHeaders 只属于客户端与网关之间, 不转发给上游的请求头
+var clientHeaders = []string{
+	"Cookie",
+	"Origin",
+	"Referer",
+}
+
+// ProxyOptions 反向代理配置
+type ProxyOptions struct {
+	//上游地址 默认DefaultUpstreamURL, 测试时可以指向本地服务
+	UpstreamURL string
+	//为空时使用不限制总时长的client, 以支持长时间的流式响应
+	Client *http.Client
+	//请求体大小限制 默认10MB, 失败切换时需要重放请求体
+	MaxBodyBytes int64
+	//成员选择策略及失败冷却 默认RoundRobin, 冷却1分钟
+	Balancer *Balancer
+	//sticky 使用的会话标识 默认读取DefaultStickyHeader
+	StickyKey func(r *http.Request) string
+}
+
+type proxy struct {
+	gateway *Gateway
+	opts    ProxyOptions
+}
+
+// Proxy
+//
+//	@Description: OpenAI 兼容的反向代理, 使用 Authorization: Bearer pk-xxx/fk-xxx
+//	将请求中的token 解析为成员的access token 或sk key 后转发/v1/* 请求, 流式响应(SSE)直接透传
+//	成员顺序由ProxyOptions.Balancer 决定, 上游返回401/429 时切换到下一个成员
+//	fk 的site_limit 按请求的Origin(或Referer) 校验, show_conversations 为false 时拒绝读取历史会话的请求
+//	@receiver g
+//	@param opts
+//	@return http.Handler
+func (g *Gateway) Proxy(opts ProxyOptions) http.Handler {
+	if opts.UpstreamURL == "" {
+		opts.UpstreamURL = DefaultUpstreamURL
+	}
+	opts.UpstreamURL = strings.TrimRight(opts.UpstreamURL, "/")
+	if opts.Client == nil {
+		opts.Client = transport.MustNewClient(transport.Config{ResponseHeaderTimeout: 5 * time.Minute})
+		opts.Client.Timeout = 0
+	}
+	if opts.MaxBodyBytes == 0 {
+		opts.MaxBodyBytes = 10 << 20
+	}
+	if opts.Balancer == nil {
+		opts.Balancer = NewBalancer(RoundRobin(), time.Minute)
+	}
+	g.mu.Lock()
+	g.forgetters = append(g.forgetters, opts.Balancer)
+	g.mu.Unlock()
+	if opts.StickyKey == nil {
+		opts.StickyKey = func(r *http.Request) string {
+			return r.Header.Get(DefaultStickyHeader)
+		}
+	}
+	return &proxy{gateway: g, opts: opts}
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/v1/") {
+		writeProxyError(w, http.StatusNotFound, "not_found", "only /v1/* is proxied")
+		return
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	members, err := p.gateway.resolve(token, r)
+	if err != nil {
+		if errors.Is(err, ErrSiteNotAllowed) || errors.Is(err, ErrConversationsHidden) {
+			writeProxyError(w, http.StatusForbidden, "permission_denied", err.Error())
+			return
+		}
+		writeProxyError(w, http.StatusUnauthorized, "invalid_api_key", err.Error())
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, p.opts.MaxBodyBytes+1))
+	if err != nil {
+		writeProxyError(w, http.StatusBadRequest, "invalid_request_error", "read request body failed: "+err.Error())
+		return
+	}
+	if int64(len(body)) > p.opts.MaxBodyBytes {
+		writeProxyError(w, http.StatusRequestEntityTooLarge, "invalid_request_error", "request body too large")
+		return
+	}
+
+	sel := Selection{Pool: token, Key: p.opts.StickyKey(r)}
+	members = p.opts.Balancer.Order(sel, members)
+	for index, member := range members {
+		last := index == len(members)-1
+		resp, err := p.forward(r, body, member.Credential)
+		if err != nil {
+			if r.Context().Err() != nil {
+				//客户端已断开, 不是成员的问题
+				return
+			}
+			p.opts.Balancer.Failure(member)
+			if last {
+				writeProxyError(w, http.StatusBadGateway, "upstream_error", err.Error())
+				return
+			}
+			continue
+		}
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			p.opts.Balancer.Failure(member)
+		} else {
+			p.opts.Balancer.Success(sel, member)
+		}
+		if (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusTooManyRequests) && !last {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			continue
+		}
+		copyResponse(w, resp)
+		return
+	}
+}
+
+// forward 使用credential 向上游发送请求
+func (p *proxy) forward(r *http.Request, body []byte, credential string) (*http.Response, error) {
+	target := p.opts.UpstreamURL + r.URL.Path
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = r.Header.Clone()
+	for _, header := range hopHeaders {
+		req.Header.Del(header)
+	}
+	for _, header := range clientHeaders {
+		req.Header.Del(header)
+	}
+	req.Header.Set("Authorization", "Bearer "+credential)
+	return p.opts.Client.Do(req)
+}
+
+// copyResponse 透传上游响应, 每次写入后flush 以支持SSE
+func copyResponse(w http.ResponseWriter, resp *http.Response) {
+	defer resp.Body.Close()
+	for _, header := range hopHeaders {
+		resp.Header.Del(header)
+	}
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// resolve
+//
+//	@Description: 将fk 解析为access token, pk 解析为所有可用成员的access token 或sk key
+//	pk 中不允许处理该请求的fk 会被跳过
+//	@receiver g
+//	@param token
+//	@param r
+//	@return []Member
+//	@return error
+func (g *Gateway) resolve(token string, r *http.Request) ([]Member, error) {
+	switch {
+	case strings.HasPrefix(token, "fk-"):
+		member, err := g.member(token)
+		if err != nil {
+			return nil, err
+		}
+		if err := g.permit(token, r); err != nil {
+			return nil, err
+		}
+		return []Member{member}, nil
+	case strings.HasPrefix(token, "pk-"):
+		keys, err := g.PoolMembers(token)
+		if err != nil {
+			return nil, err
+		}
+		var members []Member
+		var denied error
+		for _, key := range keys {
+			if strings.HasPrefix(key, "sk-") {
+				members = append(members, Member{Key: key, Credential: key, Plan: SkKeyPlan})
+				continue
+			}
+			member, err := g.member(key)
+			if err != nil {
+				continue
+			}
+			if err := g.permit(key, r); err != nil {
+				denied = err
+				continue
+			}
+			members = append(members, member)
+		}
+		if len(members) == 0 {
+			if denied != nil {
+				return nil, denied
+			}
+			return nil, ErrNoAvailableMember
+		}
+		return members, nil
+	}
+	return nil, errors.New("authorization must be Bearer pk-xxx or fk-xxx")
+}
+
+// permit
+//
+//	@Description: 按fk 的site_limit 和show_conversations 检查请求
+//	@receiver g
+//	@param fk
+//	@param r
+//	@return error
+func (g *Gateway) permit(fk string, r *http.Request) error {
+	g.mu.RLock()
+	share, ok := g.shares[fk]
+	if !ok {
+		g.mu.RUnlock()
+		return ErrTokenNotFound
+	}
+	siteLimit, showConversations := share.SiteLimit, share.ShowConversations
+	g.mu.RUnlock()
+	if siteLimit != "" && !sameSite(siteLimit, requestOrigin(r)) {
+		return ErrSiteNotAllowed
+	}
+	if !showConversations && r.Method != http.MethodPost {
+		for _, path := range conversationPaths {
+			if r.URL.Path == path || strings.HasPrefix(r.URL.Path, path+"/") {
+				return ErrConversationsHidden
+			}
+		}
+	}
+	return nil
+}
+
+// requestOrigin 返回请求的Origin, 没有时使用Referer 的scheme://host
+func requestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "null" {
+		return origin
+	}
+	return r.Header.Get("Referer")
+}
+
+// sameSite 比较两个地址的scheme 和host
+func sameSite(siteLimit string, origin string) bool {
+	limit, err := url.Parse(siteLimit)
+	if err != nil {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return strings.EqualFold(limit.Scheme, u.Scheme) && strings.EqualFold(limit.Host, u.Host)
+}
+
+// writeProxyError 以OpenAI 的错误格式返回
+func writeProxyError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{
+			"message": message,
+			"type":    code,
+			"code":    code,
+		},
+	})
+}
@@ -0,0 +1,177 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"github.com/fireinrain/opaitokens/fakeopen"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyFailoverAndStream(t *testing.T) {
+	g, err := New(Options{Verify: acceptAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := time.Now().Unix() + 3600
+	limited := testAccessToken("user-a", exp)
+	fkA, _ := g.RegisterShareToken(fakeopen.SharedTokenReq{UniqueName: "team", AccessToken: limited})
+	pool, err := g.UpdatePool(fakeopen.PooledTokenReq{ShareTokens: []string{fkA.TokenKey, "sk-member"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var auths []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"stream":true}` || r.URL.Path != "/v1/chat/completions" || r.URL.RawQuery != "a=1" {
+			t.Errorf("unexpected upstream request %s?%s %s", r.URL.Path, r.URL.RawQuery, body)
+		}
+		if r.Header.Get("Authorization") == "Bearer "+limited {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{"data: 1\n\n", "data: [DONE]\n\n"} {
+			io.WriteString(w, chunk)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer upstream.Close()
+	server := httptest.NewServer(g.Proxy(ProxyOptions{UpstreamURL: upstream.URL + "/"}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/v1/chat/completions?a=1", strings.NewReader(`{"stream":true}`))
+	req.Header.Set("Authorization", "Bearer "+pool.PoolToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %s %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	var events []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			events = append(events, line)
+		}
+	}
+	if strings.Join(events, ",") != "data: 1,data: [DONE]" {
+		t.Fatalf("unexpected events %v", events)
+	}
+	if strings.Join(auths, ",") != "Bearer "+limited+",Bearer sk-member" {
+		t.Fatalf("unexpected upstream authorizations %v", auths)
+	}
+}
+
+func TestProxyRejectsUnknownToken(t *testing.T) {
+	g, _ := New(Options{Verify: acceptAll})
+	server := httptest.NewServer(g.Proxy(ProxyOptions{UpstreamURL: "http://127.0.0.1:1"}))
+	defer server.Close()
+	for _, auth := range []string{"Bearer fk-unknown", "Bearer sk-direct", ""} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/v1/models", nil)
+		req.Header.Set("Authorization", auth)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("%q: status = %d, want 401", auth, resp.StatusCode)
+		}
+	}
+}
+
+func TestProxyEnforcesShareLimits(t *testing.T) {
+	g, _ := New(Options{Verify: acceptAll})
+	fk, err := g.RegisterShareToken(fakeopen.SharedTokenReq{UniqueName: "team", AccessToken: testAccessToken("user-a", time.Now().Unix()+3600), SiteLimit: "https://chat.example.com/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var forwarded http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Clone()
+	}))
+	defer upstream.Close()
+	server := httptest.NewServer(g.Proxy(ProxyOptions{UpstreamURL: upstream.URL}))
+	defer server.Close()
+
+	cases := []struct {
+		method string
+		origin string
+		status int
+	}{
+		{http.MethodPost, "", http.StatusForbidden},
+		{http.MethodPost, "https://evil.example.com", http.StatusForbidden},
+		{http.MethodPost, "https://chat.example.com", http.StatusOK},
+		{http.MethodGet, "https://chat.example.com", http.StatusForbidden},
+	}
+	for _, c := range cases {
+		forwarded = nil
+		req, _ := http.NewRequest(c.method, server.URL+"/v1/chat/completions", strings.NewReader("{}"))
+		req.Header.Set("Authorization", "Bearer "+fk.TokenKey)
+		req.Header.Set("Cookie", "session=secret")
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("%s %q: status = %d, want %d", c.method, c.origin, resp.StatusCode, c.status)
+		}
+		if forwarded != nil && (forwarded.Get("Cookie") != "" || forwarded.Get("Origin") != "") {
+			t.Errorf("client headers forwarded upstream: %v", forwarded)
+		}
+	}
+}
+
+func TestProxyClientCancelIsNotMemberFailure(t *testing.T) {
+	g, _ := New(Options{Verify: acceptAll})
+	fk, _ := g.RegisterShareToken(fakeopen.SharedTokenReq{UniqueName: "team", AccessToken: testAccessToken("user-a", time.Now().Unix()+3600)})
+	started := make(chan struct{})
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+	balancer := NewBalancer(RoundRobin(), time.Minute)
+	proxy := g.Proxy(ProxyOptions{UpstreamURL: upstream.URL, Balancer: balancer})
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		proxy.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/v1/chat/completions", strings.NewReader("{}"))
+	req.Header.Set("Authorization", "Bearer "+fk.TokenKey)
+	go func() {
+		<-started
+		cancel()
+	}()
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+	<-done
+	balancer.mu.Lock()
+	defer balancer.mu.Unlock()
+	if len(balancer.cooling) != 0 {
+		t.Fatalf("cancelled request should not cool down members: %v", balancer.cooling)
+	}
+}
@@ -1,5 +1,5 @@
-module opaitokens
+module github.com/fireinrain/opaitokens
 
 go 1.19
 
-require github.com/fireinrain/opaitokens v0.0.0-20230520093303-9a0477ac68b5
+require golang.org/x/crypto v0.9.0
@@ -0,0 +1,38 @@
+package opaitokens
+
+import (
+	"github.com/fireinrain/opaitokens/health"
+)
+
+// RemoveDeadMembers
+//
+//	@Description: 根据健康检查结果从pool中移除已过期或被撤销的成员, pk保持不变
+//	@receiver receiver
+//	@param pool
+//	@param report
+//	@return PoolMembers
+//	@return PoolDiff
+//	@return error
+func (receiver *FakeOpenTokens) RemoveDeadMembers(pool PoolMembers, report health.Report) (PoolMembers, PoolDiff, error) {
+	return receiver.RemoveFromPool(pool, report.DeadTokens()...)
+}
+
+// PoolHealthTargets
+//
+//	@Description: 生成pool token 以及其成员的检查目标
+//	@param pool
+//	@return []health.Target
+func PoolHealthTargets(pool PoolMembers) []health.Target {
+	var targets []health.Target
+	if pool.PoolToken != "" {
+		targets = append(targets, health.Target{Name: maskKey(pool.PoolToken), Kind: health.KindPoolToken, Token: pool.PoolToken})
+	}
+	for _, token := range pool.ShareTokens {
+		kind := health.KindShareToken
+		if skKeyPattern.MatchString(token) {
+			kind = health.KindAPIKey
+		}
+		targets = append(targets, health.Target{Name: maskKey(token), Kind: kind, Token: token})
+	}
+	return targets
+}
@@ -0,0 +1,242 @@
+package health
+
+import (
+	"context"
+	"github.com/fireinrain/opaitokens/internal/transport"
+	"github.com/fireinrain/opaitokens/utils"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 检查access token, share token(fk), pool token(pk) 以及 sk key 是否可用
+
+// DefaultEndpoint 默认探测地址, 使用token作为Bearer请求模型列表
+const DefaultEndpoint = "https://ai.fakeopen.com/v1/models"
+
+// DefaultAPIKeyEndpoint sk key 默认使用openai官方的模型列表
+const DefaultAPIKeyEndpoint = "https://api.openai.com/v1/models"
+
+type Status string
+
+const (
+	StatusValid       Status = "valid"
+	StatusExpired     Status = "expired"
+	StatusRevoked     Status = "revoked"
+	StatusRateLimited Status = "rate_limited"
+	StatusUnknown     Status = "unknown"
+)
+
+type Kind string
+
+const (
+	KindAccessToken Kind = "access_token"
+	KindShareToken  Kind = "share_token"
+	KindPoolToken   Kind = "pool_token"
+	KindAPIKey      Kind = "api_key"
+)
+
+// Target 需要检查的token
+type Target struct {
+	//账号email或者其他标识
+	Name  string `json:"name"`
+	Kind  Kind   `json:"kind"`
+	Token string `json:"-"`
+}
+
+// Result 单个token的检查结果
+type Result struct {
+	Target     Target        `json:"target"`
+	Status     Status        `json:"status"`
+	HTTPStatus int           `json:"http_status,omitempty"`
+	Detail     string        `json:"detail,omitempty"`
+	Latency    time.Duration `json:"latency"`
+	CheckedAt  int64         `json:"checked_at"`
+}
+
+// Report 一次批量检查的结果
+type Report struct {
+	Results []Result `json:"results"`
+}
+
+// ByStatus
+//
+//	@Description: 筛选指定状态的结果
+//	@receiver r
+//	@param status
+//	@return []Result
+func (r Report) ByStatus(status Status) []Result {
+	var results []Result
+	for _, result := range r.Results {
+		if result.Status == status {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// DeadTokens
+//
+//	@Description: 已过期或被撤销的token, 可用于从pool中移除
+//	@receiver r
+//	@return []string
+func (r Report) DeadTokens() []string {
+	var tokens []string
+	for _, result := range r.Results {
+		if result.Status == StatusExpired || result.Status == StatusRevoked {
+			tokens = append(tokens, result.Target.Token)
+		}
+	}
+	return tokens
+}
+
+// AliveTokens
+//
+//	@Description: 可用或者被限流(仍然有效)的token
+//	@receiver r
+//	@return []string
+func (r Report) AliveTokens() []string {
+	var tokens []string
+	for _, result := range r.Results {
+		if result.Status == StatusValid || result.Status == StatusRateLimited {
+			tokens = append(tokens, result.Target.Token)
+		}
+	}
+	return tokens
+}
+
+// Checker token可用性检查
+type Checker struct {
+	//默认探测地址
+	Endpoint string
+	//按token类型覆盖探测地址
+	Endpoints   map[Kind]string
+	Client      *http.Client
+	Concurrency int
+}
+
+// NewChecker
+//
+//	@Description: 创建检查器, endpoint为空时使用DefaultEndpoint
+//	@param endpoint
+//	@return *Checker
+func NewChecker(endpoint string) *Checker {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	return &Checker{
+		Endpoint:    endpoint,
+		Endpoints:   map[Kind]string{KindAPIKey: DefaultAPIKeyEndpoint},
+		Client:      transport.MustNewClient(transport.Config{Timeout: 30 * time.Second}),
+		Concurrency: 4,
+	}
+}
+
+func (c *Checker) endpoint(kind Kind) string {
+	if endpoint, ok := c.Endpoints[kind]; ok && endpoint != "" {
+		return endpoint
+	}
+	return c.Endpoint
+}
+
+// Check
+//
+//	@Description: 检查单个token, access token 会先根据jwt中的exp判断是否过期
+//	@receiver c
+//	@param ctx
+//	@param target
+//	@return Result
+func (c *Checker) Check(ctx context.Context, target Target) Result {
+	start := time.Now()
+	result := Result{Target: target, Status: StatusUnknown, CheckedAt: start.Unix()}
+	if target.Token == "" {
+		result.Status = StatusRevoked
+		result.Detail = "empty token"
+		return result
+	}
+	if target.Kind == KindAccessToken {
+		if exp, err := utils.JwtExpireAt(target.Token); err == nil && exp <= start.Unix() {
+			result.Status = StatusExpired
+			result.Detail = "jwt expired"
+			return result
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint(target.Kind), nil)
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	req.Header.Set("Authorization", "Bearer "+target.Token)
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	result.Latency = time.Since(start)
+	if err != nil {
+		result.Detail = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	result.HTTPStatus = resp.StatusCode
+	result.Status, result.Detail = Classify(resp.StatusCode, string(body))
+	return result
+}
+
+// Classify
+//
+//	@Description: 根据http状态码和响应内容判断token状态
+//	@param statusCode
+//	@param body
+//	@return Status
+//	@return string
+func Classify(statusCode int, body string) (Status, string) {
+	lower := strings.ToLower(body)
+	switch {
+	case statusCode >= 200 && statusCode < 300:
+		return StatusValid, ""
+	case statusCode == http.StatusTooManyRequests:
+		return StatusRateLimited, strings.TrimSpace(body)
+	case statusCode == http.StatusUnauthorized:
+		if strings.Contains(lower, "expired") {
+			return StatusExpired, strings.TrimSpace(body)
+		}
+		return StatusRevoked, strings.TrimSpace(body)
+	case statusCode == http.StatusForbidden:
+		if strings.Contains(lower, "deactivated") || strings.Contains(lower, "revoked") || strings.Contains(lower, "invalid") {
+			return StatusRevoked, strings.TrimSpace(body)
+		}
+	}
+	return StatusUnknown, strings.TrimSpace(body)
+}
+
+// CheckAll
+//
+//	@Description: 并发检查所有token, 并发数由Concurrency控制, 结果顺序与targets一致
+//	@receiver c
+//	@param ctx
+//	@param targets
+//	@return Report
+func (c *Checker) CheckAll(ctx context.Context, targets []Target) Report {
+	concurrency := c.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	results := make([]Result, len(targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, target Target) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = c.Check(ctx, target)
+		}(i, target)
+	}
+	wg.Wait()
+	return Report{Results: results}
+}
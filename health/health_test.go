@@ -0,0 +1,72 @@
+package health
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckAll(t *testing.T) {
+	var inflight, maxInflight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInflight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInflight, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		switch r.Header.Get("Authorization") {
+		case "Bearer fk-valid", "Bearer pk-valid":
+			w.Write([]byte(`{"data": []}`))
+		case "Bearer fk-expired":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"detail": "token expired"}`))
+		case "Bearer sk-revoked":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"code": "invalid_api_key"}}`))
+		case "Bearer fk-limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	expiredJwt := "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(`{"exp":1600000000}`)) + ".sig"
+	targets := []Target{
+		{Name: "a", Kind: KindShareToken, Token: "fk-valid"},
+		{Name: "b", Kind: KindShareToken, Token: "fk-expired"},
+		{Name: "c", Kind: KindAPIKey, Token: "sk-revoked"},
+		{Name: "d", Kind: KindShareToken, Token: "fk-limited"},
+		{Name: "e", Kind: KindPoolToken, Token: "pk-valid"},
+		{Name: "f", Kind: KindShareToken, Token: "fk-unknown"},
+		{Name: "g", Kind: KindAccessToken, Token: expiredJwt},
+	}
+	checker := NewChecker(server.URL)
+	checker.Endpoints[KindAPIKey] = server.URL
+	checker.Concurrency = 2
+	report := checker.CheckAll(context.Background(), targets)
+
+	want := []Status{StatusValid, StatusExpired, StatusRevoked, StatusRateLimited, StatusValid, StatusUnknown, StatusExpired}
+	for i, result := range report.Results {
+		if result.Status != want[i] {
+			t.Errorf("target %s: got %s, want %s", result.Target.Name, result.Status, want[i])
+		}
+	}
+	if maxInflight > 2 {
+		t.Errorf("concurrency exceeded: %d", maxInflight)
+	}
+	if dead := report.DeadTokens(); len(dead) != 3 {
+		t.Errorf("unexpected dead tokens: %v", dead)
+	}
+	if alive := report.AliveTokens(); len(alive) != 3 {
+		t.Errorf("unexpected alive tokens: %v", alive)
+	}
+}
@@ -0,0 +1,24 @@
+package opaitokens
+
+import (
+	"github.com/fireinrain/opaitokens/health"
+	"testing"
+)
+
+func TestPoolHealthTargets(t *testing.T) {
+	pool := PoolMembers{PoolToken: "pk-xxxx", ShareTokens: []string{"fk-a", "sk-bbbbbbbbbbbbbbbbbbbbbbbb"}}
+	targets := PoolHealthTargets(pool)
+	if len(targets) != 3 || targets[0].Kind != health.KindPoolToken || targets[2].Kind != health.KindAPIKey {
+		t.Errorf("unexpected targets: %+v", targets)
+	}
+
+	report := health.Report{Results: []health.Result{
+		{Target: health.Target{Token: "fk-a"}, Status: health.StatusValid},
+		{Target: health.Target{Token: "fk-c"}, Status: health.StatusRevoked},
+	}}
+	receiver := &FakeOpenTokens{}
+	updated, diff, err := receiver.RemoveDeadMembers(pool, report)
+	if err != nil || !diff.Empty() || updated.PoolToken != "pk-xxxx" {
+		t.Errorf("pool should stay unchanged: %v %v %v", updated, diff, err)
+	}
+}
@@ -0,0 +1,183 @@
+package transport
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// 所有包共用的http client 工厂, 统一代理, TLS, 超时以及默认请求头
+
+const DefaultUserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/111.0.0.0 Safari/537.36"
+
+// Config client 配置, 零值字段使用默认值
+type Config struct {
+	//代理地址 如 http://127.0.0.1:7890 或 socks5://127.0.0.1:1080 为空时使用HTTP_PROXY/HTTPS_PROXY环境变量
+	ProxyURL string
+	//跳过证书校验
+	InsecureSkipVerify bool
+	//整个请求的超时 默认100秒
+	Timeout time.Duration
+	//建立连接超时 默认30秒
+	DialTimeout time.Duration
+	//TLS握手超时 默认10秒
+	TLSHandshakeTimeout time.Duration
+	//等待响应头超时 为0时不限制
+	ResponseHeaderTimeout time.Duration
+	//空闲连接保留时间 默认90秒
+	IdleConnTimeout time.Duration
+	//连接池大小 默认100, 每个host默认10
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	//为空时使用DefaultUserAgent
+	UserAgent string
+	//请求中没有设置的请求头使用这里的值
+	Headers http.Header
+	//为空时不保存cookie
+	Jar http.CookieJar
+}
+
+func (c Config) withDefaults() Config {
+	if c.Timeout == 0 {
+		c.Timeout = 100 * time.Second
+	}
+	if c.DialTimeout == 0 {
+		c.DialTimeout = 30 * time.Second
+	}
+	if c.TLSHandshakeTimeout == 0 {
+		c.TLSHandshakeTimeout = 10 * time.Second
+	}
+	if c.IdleConnTimeout == 0 {
+		c.IdleConnTimeout = 90 * time.Second
+	}
+	if c.MaxIdleConns == 0 {
+		c.MaxIdleConns = 100
+	}
+	if c.MaxIdleConnsPerHost == 0 {
+		c.MaxIdleConnsPerHost = 10
+	}
+	if c.UserAgent == "" {
+		c.UserAgent = DefaultUserAgent
+	}
+	return c
+}
+
+// NewTransport
+//
+//	@Description: 创建带连接池的transport
+//	@param cfg
+//	@return *http.Transport
+//	@return error 代理地址无效
+func NewTransport(cfg Config) (*http.Transport, error) {
+	cfg = cfg.withDefaults()
+	proxy := http.ProxyFromEnvironment
+	if cfg.ProxyURL != "" {
+		u, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(u)
+	}
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify},
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		ForceAttemptHTTP2:     true,
+	}, nil
+}
+
+// NewClient
+//
+//	@Description: 创建client, 请求未设置User-Agent等请求头时补充默认值
+//	@param cfg
+//	@return *http.Client
+//	@return error
+func NewClient(cfg Config) (*http.Client, error) {
+	cfg = cfg.withDefaults()
+	tr, err := NewTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	headers := cfg.Headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+	if headers.Get("User-Agent") == "" {
+		headers.Set("User-Agent", cfg.UserAgent)
+	}
+	return &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: &headerTransport{base: tr, headers: headers},
+		Jar:       cfg.Jar,
+	}, nil
+}
+
+// MustNewClient 同NewClient, 配置无效时panic, 用于固定配置
+func MustNewClient(cfg Config) *http.Client {
+	client, err := NewClient(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+var (
+	defaultOnce   sync.Once
+	defaultClient *http.Client
+)
+
+// Default
+//
+//	@Description: auth, fakeopen 以及根包默认共用的client
+//	与之前的行为保持一致: 跳过证书校验, 使用环境变量代理, 保存cookie
+//	@return *http.Client
+func Default() *http.Client {
+	defaultOnce.Do(func() {
+		jar, _ := cookiejar.New(nil)
+		defaultClient = MustNewClient(Config{InsecureSkipVerify: true, Jar: jar})
+	})
+	return defaultClient
+}
+
+// headerTransport 为请求补充默认请求头
+type headerTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var cloned *http.Request
+	for key, values := range t.headers {
+		if req.Header.Get(key) != "" {
+			continue
+		}
+		if cloned == nil {
+			cloned = req.Clone(req.Context())
+		}
+		cloned.Header[key] = values
+	}
+	if cloned == nil {
+		return t.base.RoundTrip(req)
+	}
+	return t.base.RoundTrip(cloned)
+}
+
+// CloseIdleConnections 使client.CloseIdleConnections 生效
+func (t *headerTransport) CloseIdleConnections() {
+	if c, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
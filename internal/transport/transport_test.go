@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewClientHeaders(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{Timeout: 5 * time.Second, Headers: http.Header{"Accept": []string{"application/json"}}})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Accept", "text/plain")
+	if _, err := client.Do(req); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if got.Get("User-Agent") != DefaultUserAgent {
+		t.Errorf("default user agent expected: %q", got.Get("User-Agent"))
+	}
+	if got.Get("Accept") != "text/plain" {
+		t.Errorf("request header should win: %q", got.Get("Accept"))
+	}
+	if req.Header.Get("User-Agent") != "" {
+		t.Error("original request should not be modified")
+	}
+	if client.Timeout != 5*time.Second {
+		t.Errorf("unexpected timeout: %v", client.Timeout)
+	}
+}
+
+func TestNewTransportProxy(t *testing.T) {
+	if _, err := NewTransport(Config{ProxyURL: "://bad"}); err == nil {
+		t.Error("invalid proxy should fail")
+	}
+	tr, err := NewTransport(Config{ProxyURL: "http://127.0.0.1:7890"})
+	if err != nil {
+		t.Fatalf("new transport: %v", err)
+	}
+	req, _ := http.NewRequest("GET", "https://ai.fakeopen.com", nil)
+	proxy, _ := tr.Proxy(req)
+	if proxy == nil || proxy.Host != "127.0.0.1:7890" {
+		t.Errorf("unexpected proxy: %v", proxy)
+	}
+	if Default() != Default() {
+		t.Error("default client should be shared")
+	}
+}
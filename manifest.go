@@ -0,0 +1,229 @@
+package opaitokens
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/fireinrain/opaitokens/health"
+	"github.com/fireinrain/opaitokens/utils"
+	"os"
+	"time"
+)
+
+// DefaultRenewBefore share token 到期前多久主动续期
+const DefaultRenewBefore = 3 * 24 * time.Hour
+
+// PoolManifestMember pool中的一个账号
+type PoolManifestMember struct {
+	Email      string `json:"email"`
+	ShareToken string `json:"share_token"`
+	//share token 过期时间 unix秒
+	ExpireAt int64 `json:"expire_at"`
+}
+
+// PoolManifest 记录pool token 与账号, share token 的对应关系
+type PoolManifest struct {
+	PoolToken  string `json:"pool_token"`
+	UniqueName string `json:"unique_name"`
+	//到期前多少秒续期 为0时使用DefaultRenewBefore
+	RenewBefore int64                `json:"renew_before"`
+	Members     []PoolManifestMember `json:"members"`
+	UpdatedAt   int64                `json:"updated_at"`
+}
+
+// SyncReport SyncPool的执行结果
+type SyncReport struct {
+	Kept        []string         `json:"kept"`
+	Renewed     []string         `json:"renewed"`
+	Removed     []string         `json:"removed"`
+	Failed      []ExcludedMember `json:"failed"`
+	Diff        PoolDiff         `json:"diff"`
+	PoolUpdated bool             `json:"pool_updated"`
+}
+
+// LoadPoolManifest
+//
+//	@Description: 从json文件读取manifest
+//	@param path
+//	@return *PoolManifest
+//	@return error
+func LoadPoolManifest(path string) (*PoolManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("read pool manifest failed: " + err.Error())
+	}
+	manifest := &PoolManifest{}
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, errors.New("unmarshal pool manifest failed: " + err.Error())
+	}
+	return manifest, nil
+}
+
+// Save
+//
+//	@Description: 将manifest写入json文件(先写临时文件再替换)
+//	@receiver m
+//	@param path
+//	@return error
+func (m *PoolManifest) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.New("marshal pool manifest failed: " + err.Error())
+	}
+	err = utils.WriteFileAtomic(path, data, 0600)
+	if err != nil {
+		return errors.New("write pool manifest failed: " + err.Error())
+	}
+	return nil
+}
+
+func (m *PoolManifest) renewBefore() time.Duration {
+	if m.RenewBefore > 0 {
+		return time.Duration(m.RenewBefore) * time.Second
+	}
+	return DefaultRenewBefore
+}
+
+// needsRenew
+//
+//	@Description: share token 为空, 已过期或即将过期时需要续期
+//	@receiver m
+//	@param member
+//	@param now
+//	@return bool
+func (m *PoolManifest) needsRenew(member PoolManifestMember, now time.Time) bool {
+	if member.ShareToken == "" || member.ExpireAt <= 0 {
+		return true
+	}
+	return time.Unix(member.ExpireAt, 0).Sub(now) <= m.renewBefore()
+}
+
+// MarkDeadMembers
+//
+//	@Description: 将健康检查中已过期或被撤销的share token 标记为需要续期, 下一次SyncPool 会为这些账号重新获取fk
+//	获取失败时失效的fk 会从pool 中移除
+//	@receiver m
+//	@param report PoolHealthTargets 的检查结果
+//	@return []string 被标记的账号
+func (m *PoolManifest) MarkDeadMembers(report health.Report) []string {
+	dead := make(map[string]bool)
+	for _, token := range report.DeadTokens() {
+		dead[token] = true
+	}
+	var marked []string
+	for i, member := range m.Members {
+		if member.ShareToken != "" && dead[member.ShareToken] {
+			m.Members[i].ExpireAt = 0
+			marked = append(marked, member.Email)
+		}
+	}
+	return marked
+}
+
+// SyncPool
+//
+//	@Description: 将manifest中的pool同步为accounts描述的期望状态
+//	续期即将过期的share token, 只有成员变化时才更新pool
+//	提前过期或被撤销的成员需要先通过MarkDeadMembers 标记, 才会在同步时被替换
+//	@receiver receiver
+//	@param manifest
+//	@param accounts 期望的pool成员
+//	@return SyncReport
+//	@return error
+func (receiver *FakeOpenTokens) SyncPool(manifest *PoolManifest, accounts []OpenaiAccount) (SyncReport, error) {
+	candidates, _ := receiver.sourceCandidates(AccountSources(accounts), manifest.UniqueName)
+	return receiver.syncPool(manifest, candidates)
+}
+
+// SyncPoolWithRefreshToken
+//
+//	@Description: 同SyncPool, 使用refresh token 续期share token
+//	@receiver receiver
+//	@param manifest
+//	@param renewSharedTokenRFTs
+//	@return SyncReport
+//	@return error
+func (receiver *FakeOpenTokens) SyncPoolWithRefreshToken(manifest *PoolManifest, renewSharedTokenRFTs []RenewSharedTokenRFT) (SyncReport, error) {
+	candidates, _ := receiver.sourceCandidates(RefreshTokenSources(renewSharedTokenRFTs), manifest.UniqueName)
+	return receiver.syncPool(manifest, candidates)
+}
+
+func (receiver *FakeOpenTokens) syncPool(manifest *PoolManifest, candidates []poolCandidate) (SyncReport, error) {
+	report := SyncReport{}
+	if manifest.UniqueName == "" {
+		return report, errors.New("unique name of pool manifest is empty")
+	}
+	current := make(map[string]PoolManifestMember)
+	var currentTokens []string
+	for _, member := range manifest.Members {
+		current[member.Email] = member
+		if member.ShareToken != "" {
+			currentTokens = append(currentTokens, member.ShareToken)
+		}
+	}
+
+	now := receiver.conf().clock.Now()
+	desired := make(map[string]bool)
+	var members []PoolManifestMember
+	for index, candidate := range candidates {
+		if desired[candidate.member] {
+			continue
+		}
+		desired[candidate.member] = true
+		member, ok := current[candidate.member]
+		if !ok {
+			member = PoolManifestMember{Email: candidate.member}
+		}
+		if !manifest.needsRenew(member, now) {
+			report.Kept = append(report.Kept, member.Email)
+			members = append(members, member)
+			continue
+		}
+		receiver.logf("sync pool member: %v.", candidate.member)
+		token, err := candidate.fetch()
+		if err == nil && !shareTokenPattern.MatchString(token.TokenKey) {
+			err = errors.New("invalid share token returned")
+		}
+		if err != nil {
+			receiver.logf("error renew shared token for %v: %v", candidate.member, err)
+			report.Failed = append(report.Failed, ExcludedMember{Member: candidate.member, Reason: err.Error()})
+			//旧的share token 还未过期则继续保留
+			if member.ShareToken != "" && member.ExpireAt > now.Unix() {
+				members = append(members, member)
+				receiver.notifyTokenExpiring(member.Email, member.ExpireAt, err)
+			} else {
+				receiver.notifyAccountFailed(member.Email, err)
+			}
+		} else {
+			member.ShareToken = token.TokenKey
+			member.ExpireAt = token.ExpireAt
+			report.Renewed = append(report.Renewed, member.Email)
+			members = append(members, member)
+		}
+		if index < len(candidates)-1 {
+			receiver.conf().clock.Sleep(15 * time.Second)
+		}
+	}
+	for _, member := range manifest.Members {
+		if !desired[member.Email] {
+			report.Removed = append(report.Removed, member.Email)
+		}
+	}
+
+	receiver.checkPoolDegraded(manifest.PoolToken, len(members), len(desired))
+	var desiredTokens []string
+	for _, member := range members {
+		desiredTokens = append(desiredTokens, member.ShareToken)
+	}
+	pool := PoolMembers{PoolToken: manifest.PoolToken, ShareTokens: currentTokens}
+	updated, diff, err := receiver.ReplacePoolMembers(pool, desiredTokens)
+	report.Diff = diff
+	if err != nil {
+		return report, errors.New("sync pool failed: " + err.Error())
+	}
+	report.PoolUpdated = !diff.Empty() || manifest.PoolToken == ""
+	manifest.PoolToken = updated.PoolToken
+	manifest.Members = members
+	manifest.UpdatedAt = now.Unix()
+	return report, nil
+}
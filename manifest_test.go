@@ -0,0 +1,98 @@
+package opaitokens
+
+import (
+	"errors"
+	"github.com/fireinrain/opaitokens/fakeopen"
+	"github.com/fireinrain/opaitokens/health"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPoolManifestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pool.json")
+	manifest := &PoolManifest{
+		PoolToken:  "pk-xxxx",
+		UniqueName: "fireinrain",
+		Members:    []PoolManifestMember{{Email: "a@example.com", ShareToken: "fk-a", ExpireAt: 1700000000}},
+	}
+	if err := manifest.Save(path); err != nil {
+		t.Fatalf("save manifest: %v", err)
+	}
+	loaded, err := LoadPoolManifest(path)
+	if err != nil {
+		t.Fatalf("load manifest: %v", err)
+	}
+	if loaded.PoolToken != "pk-xxxx" || len(loaded.Members) != 1 || loaded.Members[0].ShareToken != "fk-a" {
+		t.Errorf("unexpected manifest: %+v", loaded)
+	}
+}
+
+func TestSyncPoolKeepsFreshMembers(t *testing.T) {
+	expireAt := time.Now().Add(10 * 24 * time.Hour).Unix()
+	manifest := &PoolManifest{
+		PoolToken:  "pk-xxxx",
+		UniqueName: "fireinrain",
+		Members: []PoolManifestMember{
+			{Email: "a@example.com", ShareToken: "fk-a", ExpireAt: expireAt},
+			{Email: "b@example.com", ShareToken: "fk-b", ExpireAt: expireAt},
+		},
+	}
+	fetch := func() (SharedTokenResult, error) {
+		return SharedTokenResult{}, errors.New("should not renew")
+	}
+	candidates := []poolCandidate{
+		{member: "b@example.com", fetch: fetch},
+		{member: "a@example.com", fetch: fetch},
+	}
+	receiver := &FakeOpenTokens{}
+	report, err := receiver.syncPool(manifest, candidates)
+	if err != nil {
+		t.Fatalf("sync pool: %v", err)
+	}
+	if report.PoolUpdated || len(report.Kept) != 2 || len(report.Renewed) != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if manifest.PoolToken != "pk-xxxx" {
+		t.Errorf("pool token changed: %s", manifest.PoolToken)
+	}
+}
+
+func TestSyncPoolReplacesDeadMembers(t *testing.T) {
+	expireAt := time.Now().Add(10 * 24 * time.Hour).Unix()
+	manifest := &PoolManifest{
+		UniqueName: "fireinrain",
+		Members: []PoolManifestMember{
+			{Email: "a@example.com", ShareToken: "fk-a", ExpireAt: expireAt},
+			{Email: "b@example.com", ShareToken: "fk-b", ExpireAt: expireAt},
+		},
+	}
+	report := health.Report{Results: []health.Result{
+		{Target: health.Target{Token: "fk-a"}, Status: health.StatusValid},
+		{Target: health.Target{Token: "fk-b"}, Status: health.StatusRevoked},
+	}}
+	if marked := manifest.MarkDeadMembers(report); len(marked) != 1 || marked[0] != "b@example.com" {
+		t.Fatalf("unexpected marked members: %v", marked)
+	}
+	platform := &fakePlatform{}
+	clock := &fakeClock{now: time.Now()}
+	receiver := NewFakeOpenTokens(WithPlatform(platform), WithClock(clock), WithLogger(&bufferLogger{}))
+	candidates := []poolCandidate{
+		{member: "a@example.com", fetch: func() (SharedTokenResult, error) {
+			return SharedTokenResult{}, errors.New("should not renew")
+		}},
+		{member: "b@example.com", fetch: func() (SharedTokenResult, error) {
+			return SharedTokenResult{SharedToken: fakeopen.SharedToken{TokenKey: "fk-b2", ExpireAt: expireAt}}, nil
+		}},
+	}
+	sync, err := receiver.syncPool(manifest, candidates)
+	if err != nil {
+		t.Fatalf("sync pool: %v", err)
+	}
+	if len(sync.Renewed) != 1 || sync.Renewed[0] != "b@example.com" || manifest.Members[1].ShareToken != "fk-b2" {
+		t.Errorf("dead member should be replaced: %+v %+v", sync, manifest.Members)
+	}
+	if clock.slept != 0 {
+		t.Errorf("no sleep expected after the last member: %v", clock.slept)
+	}
+}
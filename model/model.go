@@ -46,10 +46,12 @@ func NewOpenaiRefreshTokenReq() *OpenaiTokenRereshReq {
 
 type OpenaiRefreshedToken struct {
 	AccessToken string `json:"access_token"`
-	IDToken     string `json:"id_token"`
-	Scope       string `json:"scope"`
-	ExpiresIn   int    `json:"expires_in"`
-	TokenType   string `json:"token_type"`
+	//开启refresh token 轮换时返回新的refresh token, 旧的随即失效
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	Scope        string `json:"scope"`
+	ExpiresIn    int    `json:"expires_in"`
+	TokenType    string `json:"token_type"`
 }
 
 ///////////////////////////OpenaiRefreshedToken end/////////////////////////////////////
@@ -0,0 +1,82 @@
+package opaitokens
+
+import (
+	"context"
+	"github.com/fireinrain/opaitokens/notify"
+	"time"
+)
+
+// notify
+//
+//	@Description: 发送通知, 未设置Notifier时忽略, 发送失败只记录日志
+//	@receiver receiver
+//	@param event
+func (receiver *FakeOpenTokens) notify(event notify.Event) {
+	if receiver.Notifier == nil {
+		return
+	}
+	if event.Time == 0 {
+		event.Time = receiver.conf().clock.Now().Unix()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := receiver.Notifier.Notify(ctx, event); err != nil {
+		receiver.logf("send %v notification failed: %v", event.Type, err)
+	}
+}
+
+func (receiver *FakeOpenTokens) notifyAccountFailed(account string, err error) {
+	receiver.notify(notify.Event{
+		Type:    notify.EventAccountFailed,
+		Account: account,
+		Message: err.Error(),
+	})
+}
+
+func (receiver *FakeOpenTokens) notifyPoolUpdateFailed(poolToken string, err error) {
+	receiver.notify(notify.Event{
+		Type:      notify.EventPoolUpdateFailed,
+		PoolToken: maskKey(poolToken),
+		Message:   err.Error(),
+	})
+}
+
+// notifyTokenExpiring err 为最近一次续期失败的原因, 没有失败时为nil
+func (receiver *FakeOpenTokens) notifyTokenExpiring(account string, expireAt int64, err error) {
+	message := "token is about to expire"
+	if err != nil {
+		message = err.Error()
+	}
+	receiver.notify(notify.Event{
+		Type:     notify.EventTokenExpiring,
+		Account:  account,
+		ExpireAt: expireAt,
+		Message:  message,
+	})
+}
+
+// checkPoolDegraded
+//
+//	@Description: 可用成员比例低于PoolDegradedRatio时发送通知
+//	@receiver receiver
+//	@param poolToken
+//	@param members
+//	@param total
+func (receiver *FakeOpenTokens) checkPoolDegraded(poolToken string, members int, total int) {
+	if total == 0 {
+		return
+	}
+	ratio := receiver.PoolDegradedRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+	if float64(members)/float64(total) >= ratio {
+		return
+	}
+	receiver.notify(notify.Event{
+		Type:      notify.EventPoolDegraded,
+		PoolToken: maskKey(poolToken),
+		Members:   members,
+		Total:     total,
+	})
+}
@@ -0,0 +1,37 @@
+package opaitokens
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/fireinrain/opaitokens/notify"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFakeOpenTokensNotify(t *testing.T) {
+	var events []notify.Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event notify.Event
+		json.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+	}))
+	defer server.Close()
+
+	receiver := &FakeOpenTokens{
+		Notifier:          notify.NewWebhook(server.URL, notify.PayloadJSON, "secret"),
+		PoolDegradedRatio: 0.5,
+	}
+	receiver.checkPoolDegraded("pk-xxxxxxxxxxxx", 6, 10)
+	receiver.checkPoolDegraded("pk-xxxxxxxxxxxx", 4, 10)
+	receiver.notifyAccountFailed("a@example.com", errors.New("login failed"))
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %+v", events)
+	}
+	if events[0].Type != notify.EventPoolDegraded || events[0].Members != 4 || events[0].PoolToken != "pk-xx...xxxx" {
+		t.Errorf("unexpected pool event: %+v", events[0])
+	}
+	if events[1].Type != notify.EventAccountFailed || events[1].Account != "a@example.com" {
+		t.Errorf("unexpected account event: %+v", events[1])
+	}
+}
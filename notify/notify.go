@@ -0,0 +1,235 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fireinrain/opaitokens/internal/transport"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 续期, pool更新失败时的通知
+
+type EventType string
+
+const (
+	// EventAccountFailed 账号获取token或者续期失败
+	EventAccountFailed EventType = "account_failed"
+	// EventPoolDegraded pool可用成员比例低于阈值
+	EventPoolDegraded EventType = "pool_degraded"
+	// EventTokenExpiring token即将过期, 续期失败时附带失败原因
+	EventTokenExpiring EventType = "token_expiring"
+	// EventPoolUpdateFailed 更新pool失败
+	EventPoolUpdateFailed EventType = "pool_update_failed"
+)
+
+// Event 通知内容
+type Event struct {
+	Type      EventType `json:"type"`
+	Account   string    `json:"account,omitempty"`
+	PoolToken string    `json:"pool_token,omitempty"`
+	Message   string    `json:"message"`
+	//pool 可用成员数和总成员数
+	Members int `json:"members,omitempty"`
+	Total   int `json:"total,omitempty"`
+	//token 过期时间 unix秒
+	ExpireAt int64 `json:"expire_at,omitempty"`
+	Time     int64 `json:"time"`
+}
+
+// Text
+//
+//	@Description: 单行文本描述, 用于聊天工具
+//	@receiver e
+//	@return string
+func (e Event) Text() string {
+	parts := []string{"[opaitokens] " + string(e.Type)}
+	if e.Account != "" {
+		parts = append(parts, "account="+e.Account)
+	}
+	if e.PoolToken != "" {
+		parts = append(parts, "pool="+e.PoolToken)
+	}
+	if e.Total > 0 {
+		parts = append(parts, fmt.Sprintf("members=%d/%d", e.Members, e.Total))
+	}
+	if e.ExpireAt > 0 {
+		parts = append(parts, "expire_at="+time.Unix(e.ExpireAt, 0).UTC().Format(time.RFC3339))
+	}
+	if e.Message != "" {
+		parts = append(parts, e.Message)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Notifier 通知接口
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// NotifierFunc 使用函数实现Notifier
+type NotifierFunc func(ctx context.Context, event Event) error
+
+func (f NotifierFunc) Notify(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
+// Multi 依次通知多个Notifier, 返回最后一个错误
+type Multi []Notifier
+
+func (m Multi) Notify(ctx context.Context, event Event) error {
+	var er error
+	for _, n := range m {
+		if err := n.Notify(ctx, event); err != nil {
+			er = err
+		}
+	}
+	return er
+}
+
+type PayloadFormat string
+
+const (
+	// PayloadJSON 直接发送Event的json
+	PayloadJSON PayloadFormat = "json"
+	// PayloadSlack slack incoming webhook 格式 {"text": "..."}
+	PayloadSlack PayloadFormat = "slack"
+	// PayloadDiscord discord webhook 格式 {"content": "..."}
+	PayloadDiscord PayloadFormat = "discord"
+)
+
+// SignatureHeader 请求体的HMAC-SHA256签名, 格式为 sha256=<hex>
+const SignatureHeader = "X-Opaitokens-Signature"
+
+// Webhook 通过http webhook发送通知
+type Webhook struct {
+	URL    string
+	Format PayloadFormat
+	//签名密钥 为空时不签名
+	Secret string
+	Client *http.Client
+	//失败后的重试次数 以及第一次重试的等待时间(之后翻倍)
+	Retries    int
+	RetryDelay time.Duration
+}
+
+// NewWebhook
+//
+//	@Description: 创建webhook通知, 默认重试3次
+//	@param url
+//	@param format
+//	@param secret
+//	@return *Webhook
+func NewWebhook(url string, format PayloadFormat, secret string) *Webhook {
+	return &Webhook{
+		URL:        url,
+		Format:     format,
+		Secret:     secret,
+		Client:     transport.MustNewClient(transport.Config{Timeout: 10 * time.Second}),
+		Retries:    3,
+		RetryDelay: time.Second,
+	}
+}
+
+// Payload
+//
+//	@Description: 按格式生成请求体
+//	@receiver w
+//	@param event
+//	@return []byte
+//	@return error
+func (w *Webhook) Payload(event Event) ([]byte, error) {
+	switch w.Format {
+	case PayloadSlack:
+		return json.Marshal(map[string]string{"text": event.Text()})
+	case PayloadDiscord:
+		return json.Marshal(map[string]string{"content": event.Text()})
+	case PayloadJSON, "":
+		return json.Marshal(event)
+	}
+	return nil, errors.New("unsupported webhook payload format: " + string(w.Format))
+}
+
+// Sign
+//
+//	@Description: 计算请求体签名
+//	@param secret
+//	@param body
+//	@return string
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify
+//
+//	@Description: 发送通知, 网络错误和5xx, 429会重试
+//	@receiver w
+//	@param ctx
+//	@param event
+//	@return error
+func (w *Webhook) Notify(ctx context.Context, event Event) error {
+	if event.Time == 0 {
+		event.Time = time.Now().Unix()
+	}
+	body, err := w.Payload(event)
+	if err != nil {
+		return err
+	}
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	delay := w.RetryDelay
+	var er error
+	for attempt := 0; attempt <= w.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+		retry, err := w.send(ctx, client, body)
+		if err == nil {
+			return nil
+		}
+		er = err
+		if !retry {
+			break
+		}
+	}
+	return errors.New("send webhook failed: " + er.Error())
+}
+
+func (w *Webhook) send(ctx context.Context, client *http.Client, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.Secret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook returned status %s", resp.Status)
+}
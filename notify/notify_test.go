@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookRetryAndSignature(t *testing.T) {
+	attempts := 0
+	var received Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign("secret", body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &received)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook(server.URL, PayloadJSON, "secret")
+	webhook.RetryDelay = time.Millisecond
+	err := webhook.Notify(context.Background(), Event{Type: EventAccountFailed, Account: "a@example.com", Message: "login failed"})
+	if err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	if attempts != 2 || received.Account != "a@example.com" || received.Time == 0 {
+		t.Errorf("unexpected delivery: attempts=%d event=%+v", attempts, received)
+	}
+}
+
+func TestWebhookNoRetryOnClientError(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook(server.URL, PayloadSlack, "")
+	webhook.RetryDelay = time.Millisecond
+	if err := webhook.Notify(context.Background(), Event{Type: EventPoolDegraded}); err == nil {
+		t.Fatal("expected error")
+	}
+	if attempts != 1 {
+		t.Errorf("unexpected attempts: %d", attempts)
+	}
+}
+
+func TestWebhookPayload(t *testing.T) {
+	event := Event{Type: EventPoolDegraded, PoolToken: "pk-xx...yyyy", Members: 3, Total: 10}
+	slack, _ := (&Webhook{Format: PayloadSlack}).Payload(event)
+	if !strings.Contains(string(slack), `"text":"[opaitokens] pool_degraded pool=pk-xx...yyyy members=3/10"`) {
+		t.Errorf("unexpected slack payload: %s", slack)
+	}
+	discord, _ := (&Webhook{Format: PayloadDiscord}).Payload(event)
+	if !strings.HasPrefix(string(discord), `{"content":`) {
+		t.Errorf("unexpected discord payload: %s", discord)
+	}
+}
@@ -0,0 +1,62 @@
+package opaitokens
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrRefreshTokenRevoked refresh token 已失效(invalid_grant), 重试不会成功, 需要重新登录
+var ErrRefreshTokenRevoked = errors.New("refresh token revoked")
+
+// OAuthError auth0 token 接口返回的错误
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+	Status      int    `json:"-"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("oauth error %s (status %d)", e.Code, e.Status)
+	}
+	return fmt.Sprintf("oauth error %s (status %d): %s", e.Code, e.Status, e.Description)
+}
+
+// Is invalid_grant 视为ErrRefreshTokenRevoked
+func (e *OAuthError) Is(target error) bool {
+	return target == ErrRefreshTokenRevoked && e.Code == "invalid_grant"
+}
+
+// IsRefreshTokenRevoked
+//
+//	@Description: 判断错误是否由refresh token 失效导致
+//	@param err
+//	@return bool
+func IsRefreshTokenRevoked(err error) bool {
+	return errors.Is(err, ErrRefreshTokenRevoked)
+}
+
+// parseOAuthError
+//
+//	@Description: 解析非2xx响应, 响应不是oauth错误格式时使用http状态作为Code
+//	@param status
+//	@param body
+//	@return *OAuthError
+func parseOAuthError(status int, body []byte) *OAuthError {
+	oauthErr := &OAuthError{}
+	if err := json.Unmarshal(body, oauthErr); err != nil || oauthErr.Code == "" {
+		oauthErr.Code = strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+		if oauthErr.Code == "" {
+			oauthErr.Code = "http_error"
+		}
+		oauthErr.Description = strings.TrimSpace(string(body))
+		if len(oauthErr.Description) > 200 {
+			oauthErr.Description = oauthErr.Description[:200]
+		}
+	}
+	oauthErr.Status = status
+	return oauthErr
+}
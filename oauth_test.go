@@ -0,0 +1,58 @@
+package opaitokens
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMakePostRequestOAuthError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/revoked":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error": "invalid_grant", "error_description": "Unknown or invalid refresh token."}`))
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`<html>bad gateway</html>`))
+		default:
+			w.Write([]byte(`{"access_token": "eyJ"}`))
+		}
+	}))
+	defer server.Close()
+
+	resp, err := makePostRequest(server.Client(), server.URL+"/ok", []byte(`{}`))
+	if err != nil || resp != `{"access_token": "eyJ"}` {
+		t.Fatalf("unexpected response: %v %v", resp, err)
+	}
+
+	_, err = makePostRequest(server.Client(), server.URL+"/revoked", []byte(`{}`))
+	wrapped := fmt.Errorf("refresh token failed: %w", err)
+	if !IsRefreshTokenRevoked(wrapped) {
+		t.Errorf("invalid_grant should be treated as revoked: %v", err)
+	}
+	var oauthErr *OAuthError
+	if !errors.As(wrapped, &oauthErr) || oauthErr.Status != http.StatusForbidden || oauthErr.Description == "" {
+		t.Errorf("unexpected oauth error: %+v", oauthErr)
+	}
+
+	_, err = makePostRequest(server.Client(), server.URL+"/down", []byte(`{}`))
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "bad_gateway" || IsRefreshTokenRevoked(err) {
+		t.Errorf("unexpected error for non oauth body: %v", err)
+	}
+}
+
+func TestRefreshTokenE(t *testing.T) {
+	server, _ := refreshServer(t)
+	tokens := NewOpaiTokens("a@example.com", "pass", false, WithHTTPClient(&http.Client{Transport: rewriteTransport{target: server.URL}}), WithLogger(&bufferLogger{}))
+	tokens.OpenaiToken.RefreshToken = "rt-0"
+	if _, err := tokens.RefreshTokenE(); err != nil || tokens.RefreshedToken.AccessToken != "at-1" || tokens.OpenaiToken.RefreshToken != "rt-1" {
+		t.Fatalf("unexpected refresh result: %+v %v", tokens.OpenaiToken, err)
+	}
+	tokens.OpenaiToken.RefreshToken = "rt-0"
+	if _, err := tokens.RefreshTokenE(); !errors.Is(err, ErrRefreshTokenRevoked) {
+		t.Fatalf("revoked refresh token should be reported: %v", err)
+	}
+}
@@ -2,19 +2,18 @@ package opaitokens
 
 import (
 	"bytes"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/fireinrain/opaitokens/auth"
 	"github.com/fireinrain/opaitokens/fakeopen"
+	"github.com/fireinrain/opaitokens/health"
+	"github.com/fireinrain/opaitokens/internal/transport"
 	"github.com/fireinrain/opaitokens/model"
+	"github.com/fireinrain/opaitokens/notify"
 	"log"
 	"net/http"
-	"net/http/cookiejar"
-	"regexp"
 	"strings"
-	"time"
 )
 
 const OpenaiTokenBaseUrl = "https://auth0.openai.com/oauth/token"
@@ -31,9 +30,10 @@ type OpaiTokens struct {
 	OpenaiToken      model.OpenaiToken          `json:"openaiToken"`
 	RefreshedToken   model.OpenaiRefreshedToken `json:"refreshedToken"`
 	UseFakeopenProxy bool                       `json:"useFakeopenProxy"`
+	cfg              *config
 }
 
-func NewOpaiTokens(email string, password string, useFakeOpenProxy bool) *OpaiTokens {
+func NewOpaiTokens(email string, password string, useFakeOpenProxy bool, opts ...Option) *OpaiTokens {
 	if email == "" {
 		log.Fatal("email cannot be empty")
 	}
@@ -47,52 +47,86 @@ func NewOpaiTokens(email string, password string, useFakeOpenProxy bool) *OpaiTo
 		OpenaiToken:      model.OpenaiToken{},
 		RefreshedToken:   model.OpenaiRefreshedToken{},
 		UseFakeopenProxy: useFakeOpenProxy,
+		cfg:              newConfig(opts),
 	}
 }
 
-func NewOpaiTokensWithMFA(email string, password string, mfa string, useFakeOpenProxy bool) *OpaiTokens {
-	tokens := NewOpaiTokens(email, password, useFakeOpenProxy)
+func NewOpaiTokensWithMFA(email string, password string, mfa string, useFakeOpenProxy bool, opts ...Option) *OpaiTokens {
+	tokens := NewOpaiTokens(email, password, useFakeOpenProxy, opts...)
 	tokens.MFA = mfa
 	return tokens
 }
 
 func (receiver *OpaiTokens) FetchToken() *OpaiTokens {
-	auth := auth.NewAuth0(receiver.Email, receiver.Password, receiver.MFA, false)
+	cfg := receiver.conf()
+	auth := auth.NewAuth0WithConfig(receiver.Email, receiver.Password, receiver.MFA, cfg.authConfig)
 	if receiver.UseFakeopenProxy {
 		s, err := auth.Auth(false)
 		if err != nil {
-			fmt.Printf("use fakeopen proxy for auth failed: %s", err)
+			cfg.logger.Printf("use fakeopen proxy for auth failed: %s", err)
+			return receiver
 		}
-		receiver.RefreshedToken.AccessToken = auth.GetRefreshToken()
 		receiver.OpenaiToken.AccessToken = s
+		receiver.OpenaiToken.RefreshToken = auth.GetRefreshToken()
+		receiver.OpenaiToken.IDToken = auth.GetIDToken()
 		return receiver
 	}
 	codeAndUrl, err := auth.AuthForCodeUrl()
 	if err != nil {
-		fmt.Println("Error:", err)
+		cfg.logger.Printf("Error: %v", err)
 		return receiver
 	}
 	codeVeriferAndCode := strings.Split(codeAndUrl, "|")
 	codeVerifer := codeVeriferAndCode[0]
 	code := codeVeriferAndCode[1]
 	token, err := receiver.reqForToken(code, codeVerifer)
-	if err == nil {
-		receiver.OpenaiToken = token
+	if err != nil {
+		return receiver
+	}
+	if token.IDToken != "" && auth.AuthorizeRequest() != nil {
+		if err := auth.AuthorizeRequest().VerifyIDToken(token.IDToken); err != nil {
+			cfg.logger.Printf("verify id token failed: %v", err)
+			return receiver
+		}
 	}
+	receiver.OpenaiToken = token
 	return receiver
 }
 
+// RefreshToken
+//
+//	@Description: 使用refresh token 刷新access token, 没有refresh token 时重新登录, 需要判断失败原因时使用RefreshTokenE
+//	@receiver receiver
+//	@return *OpaiTokens
 func (receiver *OpaiTokens) RefreshToken() *OpaiTokens {
+	receiver.RefreshTokenE()
+	return receiver
+}
+
+// RefreshTokenE
+//
+//	@Description: 同RefreshToken, 返回失败的原因, refresh token 已失效时满足errors.Is(err, ErrRefreshTokenRevoked)
+//	刷新返回了新的refresh token 时同时更新OpenaiToken.RefreshToken
+//	@receiver receiver
+//	@return *OpaiTokens
+//	@return error
+func (receiver *OpaiTokens) RefreshTokenE() (*OpaiTokens, error) {
 	if receiver.OpenaiToken.RefreshToken == "" {
 		receiver.FetchToken()
-	} else {
-		refreshTokenStr := receiver.OpenaiToken.RefreshToken
-		token, err := receiver.refreshToken(refreshTokenStr)
-		if err == nil {
-			receiver.RefreshedToken = token
+		if receiver.OpenaiToken.AccessToken == "" {
+			return receiver, errors.New("login failed for " + receiver.Email)
 		}
+		return receiver, nil
 	}
-	return receiver
+	token, err := receiver.refreshToken(receiver.OpenaiToken.RefreshToken)
+	if err != nil {
+		return receiver, fmt.Errorf("refresh token failed: %w", err)
+	}
+	receiver.RefreshedToken = token
+	if token.RefreshToken != "" {
+		receiver.OpenaiToken.RefreshToken = token.RefreshToken
+	}
+	return receiver, nil
 }
 
 func (reciver *OpaiTokens) reqForToken(code string, codeVerifier string) (model.OpenaiToken, error) {
@@ -106,20 +140,23 @@ func (reciver *OpaiTokens) reqForToken(code string, codeVerifier string) (model.
 	// 构建POST请求数据
 	jsonData, err := json.Marshal(req)
 	if err != nil {
-		fmt.Println("json marshal error:", err)
+		reciver.conf().logger.Printf("json marshal error: %v", err)
 		return token, err
 	}
 
-	resp, err := makePostRequest(url, jsonData)
+	resp, err := makePostRequest(reciver.conf().client, url, jsonData)
 	if err != nil {
-		fmt.Println("makePost request error:", err)
+		reciver.conf().logger.Printf("makePost request error: %v", err)
 		return token, err
 	}
 	err = json.Unmarshal([]byte(resp), &token)
 	if err != nil {
-		fmt.Println("json unmarshal error:", err)
+		reciver.conf().logger.Printf("json unmarshal error: %v", err)
 		return token, err
 	}
+	if token.AccessToken == "" {
+		return token, errors.New("token response has no access token")
+	}
 
 	return token, nil
 }
@@ -134,110 +171,193 @@ func (reciver *OpaiTokens) refreshToken(refreshToken string) (model.OpenaiRefres
 
 	jsonData, err := json.Marshal(req)
 	if err != nil {
-		fmt.Println("json marshal error:", err)
+		reciver.conf().logger.Printf("json marshal error: %v", err)
 		return refreshedToken, err
 	}
-	resp, err := makePostRequest(url, jsonData)
+	resp, err := makePostRequest(reciver.conf().client, url, jsonData)
 	if err != nil {
-		fmt.Println("error for request:", err)
+		reciver.conf().logger.Printf("error for request: %v", err)
 		return refreshedToken, err
 
 	}
 	err = json.Unmarshal([]byte(resp), &refreshedToken)
 	if err != nil {
-		fmt.Println("json unmarshal error:", err)
+		reciver.conf().logger.Printf("json unmarshal error: %v", err)
 		return refreshedToken, err
 
 	}
+	if refreshedToken.AccessToken == "" {
+		return refreshedToken, errors.New("refresh token response has no access token")
+	}
 	return refreshedToken, nil
 }
 
-func makePostRequest(url string, jsonData []byte) (resp string, error error) {
-	var jar, _ = cookiejar.New(nil)
-
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		Proxy:           http.ProxyFromEnvironment,
-	}
-	client := &http.Client{
-		Timeout:   time.Second * 100,
-		Transport: tr,
-		Jar:       jar,
-	}
+func makePostRequest(client *http.Client, url string, jsonData []byte) (resp string, error error) {
 	// 创建请求
 	request, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		fmt.Println("failed to create request:", err)
-		return "", err
+		return "", fmt.Errorf("create request failed: %w", err)
 	}
 	// 设置User-Agent头部字段
 	request.Header.Set("Content-Type", "application/json")
-	request.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/111.0.0.0 Safari/537.36")
+	request.Header.Set("User-Agent", transport.DefaultUserAgent)
 	// 发送请求
 	response, err := client.Do(request)
 	if err != nil {
-		fmt.Println("POST request error:", err)
-		return "", err
+		return "", fmt.Errorf("post request failed: %w", err)
 	}
 	defer response.Body.Close()
 	buf := new(bytes.Buffer)
-	buf.ReadFrom(response.Body)
+	_, err = buf.ReadFrom(response.Body)
+	if err != nil {
+		return "", errors.New("read response failed: " + err.Error())
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return "", parseOAuthError(response.StatusCode, buf.Bytes())
+	}
 	return buf.String(), nil
 }
 
-func getLoginUrl(codeChallenge string) string {
-	encodedString := "https://auth0.openai.com/authorize?client_id=pdlLIX2Y72MIl2rhLhTE9VV9bN905kBh&audience=https%3A%2F%2Fapi.openai.com%2Fv1&redirect_uri=com.openai.chat%3A%2F%2Fauth0.openai.com%2Fios%2Fcom.openai.chat%2Fcallback&scope=openid%20email%20profile%20offline_access%20model.request%20model.read%20organization.read%20offline&response_type=code&code_challenge=w6n3Ix420Xhhu-Q5-mOOEyuPZmAsJHUbBpO8Ub7xBCY&code_challenge_method=S256&prompt=login"
-	//fmt.Println("decoded string:", decodedString)
-	re := regexp.MustCompile(`code_challenge=[^&]+`)
-	replacement := "code_challenge=" + codeChallenge
-	newURL := re.ReplaceAllString(encodedString, replacement)
-	//fmt.Println("Modified URL:", newURL)
-	//fmt.Println(escape)
-	return newURL
+type FakeOpenTokens struct {
+	//pool成员获取失败时的处理策略 默认跳过失败成员
+	MembershipPolicy MembershipPolicy
+	//失败通知 为空时不通知
+	Notifier notify.Notifier
+	//pool可用成员比例低于该值时发送通知 为0时任意成员失败都会通知
+	PoolDegradedRatio float64
+	//加入mixed pool 之前检查sk key是否可用 为空时只检查格式
+	SkKeyChecker *health.Checker
+	//注册share token 的默认选项 账号设置了ShareTokenOptions时使用账号的选项
+	ShareTokenOptions ShareTokenOptions
+	//NewFakeOpenTokens 设置的依赖 为空时使用默认配置
+	cfg *config
 }
 
-type FakeOpenTokens struct{}
-
 type OpenaiAccount struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	MFA      string `json:"mfa"`
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	MFA          string `json:"mfa"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	SessionToken string `json:"session_token,omitempty"`
+	//登录, 刷新token 以及session token 换取access token 时使用的代理 如 http://127.0.0.1:7890 或 socks5://127.0.0.1:1080
+	Proxy string   `json:"proxy,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	//该账号share token 的选项 为空时使用FakeOpenTokens.ShareTokenOptions
+	ShareTokenOptions *ShareTokenOptions `json:"share_token_options,omitempty"`
 }
 
 type RenewResult struct {
 	RenewCount   int  `json:"renew_count"`
 	RenewSuccess bool `json:"renew_success"`
+	//refresh token 已失效的账号, 需要重新登录
+	Revoked []string `json:"revoked,omitempty"`
+	//续期时获取到的refresh token 和id token
+	Credentials []AccountCredential `json:"credentials,omitempty"`
+}
+
+// RevokeResult 批量撤销fk 的结果
+type RevokeResult struct {
+	RevokeCount   int  `json:"revoke_count"`
+	RevokeSuccess bool `json:"revoke_success"`
+	//撤销失败的账号
+	Failed []string `json:"failed,omitempty"`
+}
+
+// SharedTokenResult share token 以及获取access token 时得到的refresh token 和id token
+type SharedTokenResult struct {
+	fakeopen.SharedToken
+	Email        string `json:"email"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// AccountCredential 登录时获取到的凭证, 保存refresh token 后可以不再使用密码登录
+type AccountCredential struct {
+	Email        string `json:"email"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+// Credential
+//
+//	@Description: 返回获取到的凭证, 没有refresh token 和id token 时返回false
+//	@receiver r
+//	@return AccountCredential
+//	@return bool
+func (r SharedTokenResult) Credential() (AccountCredential, bool) {
+	if r.RefreshToken == "" && r.IDToken == "" {
+		return AccountCredential{}, false
+	}
+	return AccountCredential{Email: r.Email, RefreshToken: r.RefreshToken, IDToken: r.IDToken}, true
 }
 
 // FetchSharedToken
 //
-//	@Description: 通过官方账号获取shared token
+//	@Description: 通过任意凭证获取shared token, OpenaiAccount 也实现了CredentialSource
 //	@receiver receiver
-//	@param openaiAccount
+//	@param src
 //	@param uniqueName
-//	@return fakeopen.SharedToken
+//	@return SharedTokenResult 包含登录得到的refresh token 和id token
 //	@return error
-func (receiver *FakeOpenTokens) FetchSharedToken(openaiAccount OpenaiAccount, uniqueName string) (fakeopen.SharedToken, error) {
-
-	tokens := NewOpaiTokensWithMFA(openaiAccount.Email, openaiAccount.Password, openaiAccount.MFA, true)
-	token := tokens.FetchToken()
-	//fmt.Printf("token info: %v\n", token)
-	accessToken := token.OpenaiToken.AccessToken
+func (receiver *FakeOpenTokens) FetchSharedToken(src CredentialSource, uniqueName string) (SharedTokenResult, error) {
+	result := SharedTokenResult{Email: src.Name()}
+	token, err := receiver.sourceToken(src)
+	if err != nil {
+		return result, fmt.Errorf("get access token failed: %w", err)
+	}
+	result.RefreshToken = token.RefreshToken
+	result.IDToken = token.IDToken
 	// use the access token
-	fmt.Println("current openai account: ", openaiAccount.Email)
-	fmt.Printf("fetched access token: %v \n", accessToken)
-
-	platform := fakeopen.NewAiFakeOpenPlatform()
-	req := fakeopen.SharedTokenReq{
-		UniqueName:        uniqueName,
-		AccessToken:       accessToken,
-		ExpiresIn:         0,
-		SiteLimit:         "",
-		ShowConversations: true,
-	}
-	shareToken, err := platform.GetSharedToken(req)
+	receiver.logf("current openai account: %v", src.Name())
+	result.SharedToken, err = receiver.registerSharedToken(token.AccessToken, uniqueName, receiver.shareTokenOptionsFor(src))
+	if err != nil {
+		return result, err
+	}
+	if store := receiver.conf().store; store != nil {
+		if err := store.SaveSharedToken(result); err != nil {
+			receiver.logf("save shared token for %v failed: %v", src.Name(), err)
+		}
+	}
+	return result, nil
+}
+
+// RevokeSharedToken
+//
+//	@Description: 立即撤销该凭证在uniqueName 下的fk, 用于fk 泄露时
+//	@receiver receiver
+//	@param src
+//	@param uniqueName
+//	@return fakeopen.SharedToken 撤销后的fk 信息
+//	@return error
+func (receiver *FakeOpenTokens) RevokeSharedToken(src CredentialSource, uniqueName string) (fakeopen.SharedToken, error) {
+	token, err := receiver.sourceToken(src)
+	if err != nil {
+		return fakeopen.SharedToken{}, fmt.Errorf("get access token failed: %w", err)
+	}
+	receiver.logf("revoke shared token of openai account: %v", src.Name())
+	shareToken, err := receiver.conf().platform.RevokeSharedToken(uniqueName, token.AccessToken)
+	if err != nil {
+		return shareToken, fmt.Errorf("error revoking shared token: %w", err)
+	}
+	return shareToken, nil
+}
+
+// registerSharedToken
+//
+//	@Description: 使用access token 注册fakeopen的share token
+//	@receiver receiver
+//	@param accessToken
+//	@param uniqueName
+//	@param opts
+//	@return fakeopen.SharedToken
+//	@return error
+func (receiver *FakeOpenTokens) registerSharedToken(accessToken string, uniqueName string, opts ShareTokenOptions) (fakeopen.SharedToken, error) {
+	if err := opts.Validate(); err != nil {
+		return fakeopen.SharedToken{}, err
+	}
+	shareToken, err := receiver.conf().platform.GetSharedToken(opts.request(uniqueName, accessToken))
 	if err != nil {
-		return shareToken, errors.New("error getting shared token: " + err.Error())
+		return shareToken, fmt.Errorf("error getting shared token: %w", err)
 	}
 	return shareToken, nil
 }
@@ -251,29 +371,10 @@ func (receiver *FakeOpenTokens) FetchSharedToken(openaiAccount OpenaiAccount, un
 //	@return RenewResult
 //	@return error
 func (receiver *FakeOpenTokens) RenewSharedToken(openaiAccounts []OpenaiAccount, uniqueName string) (RenewResult, error) {
-	result := RenewResult{
-		RenewCount:   0,
-		RenewSuccess: false,
-	}
 	if len(openaiAccounts) <= 0 {
 		log.Fatal("openai account is empty")
 	}
-	var er error
-	for index, account := range openaiAccounts {
-		fmt.Printf("renew shared token progress...%v/%v. ", index+1, len(openaiAccounts))
-		_, err := receiver.FetchSharedToken(account, uniqueName)
-		if err == nil {
-			result.RenewCount += 1
-		} else {
-			er = err
-		}
-		time.Sleep(time.Second * 15)
-	}
-	//全部成功刷新
-	if len(openaiAccounts) == result.RenewCount {
-		result.RenewSuccess = true
-	}
-	return result, er
+	return receiver.RenewSharedTokens(AccountSources(openaiAccounts), uniqueName)
 }
 
 // FetchSharedTokenWithRefreshToken
@@ -283,33 +384,10 @@ func (receiver *FakeOpenTokens) RenewSharedToken(openaiAccounts []OpenaiAccount,
 //	@param openaiAccountEmail
 //	@param openaiRefreshToken
 //	@param uniqueName
-//	@return fakeopen.SharedToken
+//	@return SharedTokenResult
 //	@return error
-func (receiver FakeOpenTokens) FetchSharedTokenWithRefreshToken(openaiAccountEmail string, openaiRefreshToken string, uniqueName string) (fakeopen.SharedToken, error) {
-	tokens := OpaiTokens{}
-	token, err2 := tokens.refreshToken(openaiRefreshToken)
-	if err2 != nil {
-		fmt.Println("refresh token failed: ", err2.Error())
-		return fakeopen.SharedToken{}, err2
-	}
-	// use the access token
-	fmt.Println("current openai account: ", openaiAccountEmail)
-	fmt.Printf("fetched access token: %v \n", token.AccessToken)
-
-	platform := fakeopen.NewAiFakeOpenPlatform()
-	req := fakeopen.SharedTokenReq{
-		UniqueName:        uniqueName,
-		AccessToken:       token.AccessToken,
-		ExpiresIn:         0,
-		SiteLimit:         "",
-		ShowConversations: true,
-	}
-	shareToken, err := platform.GetSharedToken(req)
-	if err != nil {
-		return shareToken, errors.New("error getting shared token: " + err.Error())
-	}
-	return shareToken, nil
-
+func (receiver FakeOpenTokens) FetchSharedTokenWithRefreshToken(openaiAccountEmail string, openaiRefreshToken string, uniqueName string) (SharedTokenResult, error) {
+	return receiver.FetchSharedToken(RefreshTokenSource{Email: openaiAccountEmail, RefreshToken: openaiRefreshToken}, uniqueName)
 }
 
 type RenewSharedTokenRFT struct {
@@ -326,29 +404,10 @@ type RenewSharedTokenRFT struct {
 //	@return RenewResult
 //	@return error
 func (receiver *FakeOpenTokens) RenewSharedTokenWithRefreshToken(renewSharedTokenRFTs []RenewSharedTokenRFT, uniqueName string) (RenewResult, error) {
-	result := RenewResult{
-		RenewCount:   0,
-		RenewSuccess: false,
-	}
 	if len(renewSharedTokenRFTs) <= 0 {
 		log.Fatal("openai refresh token list is empty")
 	}
-	var er error
-	for index, account := range renewSharedTokenRFTs {
-		fmt.Printf("renew shared token progress...%v/%v. ", index+1, len(renewSharedTokenRFTs))
-		_, err := receiver.FetchSharedTokenWithRefreshToken(account.OpenaiAccountEmail, account.OpenaiRefreshToken, uniqueName)
-		if err == nil {
-			result.RenewCount += 1
-		} else {
-			er = err
-		}
-		time.Sleep(time.Second * 15)
-	}
-	//全部成功刷新
-	if len(renewSharedTokenRFTs) == result.RenewCount {
-		result.RenewSuccess = true
-	}
-	return result, er
+	return receiver.RenewSharedTokens(RefreshTokenSources(renewSharedTokenRFTs), uniqueName)
 }
 
 // FetchPooledToken
@@ -356,44 +415,16 @@ func (receiver *FakeOpenTokens) RenewSharedTokenWithRefreshToken(renewSharedToke
 //	@Description: 通过官方账号列表获取pooled token
 //	@receiver receiver
 //	@param openaiAccounts
-//	@return fakeopen.PooledToken
+//	@return PooledTokenResult
 //	@return error
-func (receiver *FakeOpenTokens) FetchPooledToken(openaiAccounts []OpenaiAccount, uniqueName string) (fakeopen.PooledToken, error) {
+func (receiver *FakeOpenTokens) FetchPooledToken(openaiAccounts []OpenaiAccount, uniqueName string) (PooledTokenResult, error) {
 	if len(openaiAccounts) <= 0 {
 		log.Fatal("invalid openai account list")
 	}
 	if len(openaiAccounts) > PooledTokenAccountsLimit {
-		log.Println("openai account size is greater than 100,do cut off to 100")
+		receiver.logf("openai account size is greater than 100,do cut off to 100")
 	}
-	var shareTokens []string
-	for index, account := range openaiAccounts {
-		if index > PooledTokenAccountsLimit {
-			break
-		}
-		fmt.Printf("fetching pooled token progress...%v/%v. ", index+1, len(openaiAccounts))
-		token, err := receiver.FetchSharedToken(account, uniqueName)
-		//等待15秒
-		if err != nil {
-			log.Printf("error fetch shared token: %v \n", err)
-			log.Println("current account is: ", account.Email)
-		}
-		shareTokens = append(shareTokens, token.TokenKey)
-		time.Sleep(15 * time.Second)
-
-	}
-
-	platform := fakeopen.NewAiFakeOpenPlatform()
-	//tokens with shared token
-
-	req := fakeopen.PooledTokenReq{
-		ShareTokens: shareTokens,
-		PoolToken:   "",
-	}
-	token, err := platform.RenewPooledToken(req)
-	if err != nil {
-		return token, errors.New("error renewing pool token: " + err.Error())
-	}
-	return token, nil
+	return receiver.BuildPool(AccountSources(openaiAccounts), uniqueName)
 }
 
 // FetchPooledTokenWithRefreshToken
@@ -402,126 +433,36 @@ func (receiver *FakeOpenTokens) FetchPooledToken(openaiAccounts []OpenaiAccount,
 //	@receiver receiver
 //	@param renewSharedTokenRFTs
 //	@param uniqueName
-//	@return fakeopen.PooledToken
+//	@return PooledTokenResult
 //	@return error
-func (receiver *FakeOpenTokens) FetchPooledTokenWithRefreshToken(renewSharedTokenRFTs []RenewSharedTokenRFT, uniqueName string) (fakeopen.PooledToken, error) {
+func (receiver *FakeOpenTokens) FetchPooledTokenWithRefreshToken(renewSharedTokenRFTs []RenewSharedTokenRFT, uniqueName string) (PooledTokenResult, error) {
 	if len(renewSharedTokenRFTs) <= 0 {
 		log.Fatal("invalid openai refreshToken list")
 	}
 	if len(renewSharedTokenRFTs) > PooledTokenAccountsLimit {
-		log.Println("openai account size is greater than 100,do cut off to 100")
-	}
-	var shareTokens []string
-	for index, account := range renewSharedTokenRFTs {
-		if index > PooledTokenAccountsLimit {
-			break
-		}
-		fmt.Printf("fetching pooled token progress...%v/%v. ", index+1, len(renewSharedTokenRFTs))
-		token, err := receiver.FetchSharedTokenWithRefreshToken(account.OpenaiAccountEmail, account.OpenaiRefreshToken, uniqueName)
-		//等待15秒
-		if err != nil {
-			log.Printf("error fetch shared token: %v \n", err)
-			log.Println("current account is: ", account.OpenaiAccountEmail)
-		}
-		shareTokens = append(shareTokens, token.TokenKey)
-		time.Sleep(15 * time.Second)
-
+		receiver.logf("openai account size is greater than 100,do cut off to 100")
 	}
-
-	platform := fakeopen.NewAiFakeOpenPlatform()
-	//tokens with shared token
-
-	req := fakeopen.PooledTokenReq{
-		ShareTokens: shareTokens,
-		PoolToken:   "",
-	}
-	token, err := platform.RenewPooledToken(req)
-	if err != nil {
-		return token, errors.New("error renewing pool token: " + err.Error())
-	}
-	return token, nil
+	return receiver.BuildPool(RefreshTokenSources(renewSharedTokenRFTs), uniqueName)
 }
 
-func (receiver *FakeOpenTokens) FetchMixedPooledToken(openaiAccounts []OpenaiAccount, openaiSkKeys []string, uniqueName string) (fakeopen.PooledToken, error) {
+func (receiver *FakeOpenTokens) FetchMixedPooledToken(openaiAccounts []OpenaiAccount, openaiSkKeys []string, uniqueName string) (PooledTokenResult, error) {
 	if len(openaiAccounts)+len(openaiSkKeys) <= 0 {
 		log.Fatal("invalid openai account list or sk keys")
 	}
 	if len(openaiAccounts)+len(openaiSkKeys) > PooledTokenAccountsLimit {
-		log.Println("openai account + openai sk keys size is greater than 100,do cut off to 100")
-	}
-	var shareTokens []string
-	for index, account := range openaiAccounts {
-		if index > PooledTokenAccountsLimit-len(openaiSkKeys) {
-			break
-		}
-		fmt.Printf("fetching pooled token progress...%v/%v. ", index+1, len(openaiAccounts))
-		token, err := receiver.FetchSharedToken(account, uniqueName)
-		//等待15秒
-		if err != nil {
-			log.Printf("error fetch shared token: %v \n", err)
-			log.Println("current account is: ", account.Email)
-		}
-		shareTokens = append(shareTokens, token.TokenKey)
-		time.Sleep(15 * time.Second)
-
-	}
-
-	//add sk keys to shareTokens
-	shareTokens = append(shareTokens, openaiSkKeys...)
-
-	platform := fakeopen.NewAiFakeOpenPlatform()
-	//tokens with shared token
-
-	req := fakeopen.PooledTokenReq{
-		ShareTokens: shareTokens,
-		PoolToken:   "",
+		receiver.logf("openai account + openai sk keys size is greater than 100,do cut off to 100")
 	}
-	token, err := platform.RenewPooledToken(req)
-	if err != nil {
-		return token, errors.New("error renewing pool token: " + err.Error())
-	}
-	return token, nil
+	return receiver.BuildPool(append(AccountSources(openaiAccounts), SkKeySources(openaiSkKeys)...), uniqueName)
 }
 
-func (receiver *FakeOpenTokens) FetchMixedPooledTokenWithRefreshToken(renewSharedTokenRFTs []RenewSharedTokenRFT, openaiSkKeys []string, uniqueName string) (fakeopen.PooledToken, error) {
+func (receiver *FakeOpenTokens) FetchMixedPooledTokenWithRefreshToken(renewSharedTokenRFTs []RenewSharedTokenRFT, openaiSkKeys []string, uniqueName string) (PooledTokenResult, error) {
 	if len(renewSharedTokenRFTs)+len(openaiSkKeys) <= 0 {
 		log.Fatal("invalid openai account list or sk keys")
 	}
 	if len(renewSharedTokenRFTs)+len(openaiSkKeys) > PooledTokenAccountsLimit {
-		log.Println("openai account + openai sk keys size is greater than 100,do cut off to 100")
-	}
-	var shareTokens []string
-	for index, account := range renewSharedTokenRFTs {
-		if index > PooledTokenAccountsLimit-len(openaiSkKeys) {
-			break
-		}
-		fmt.Printf("fetching pooled token progress...%v/%v. ", index+1, len(renewSharedTokenRFTs))
-		token, err := receiver.FetchSharedTokenWithRefreshToken(account.OpenaiAccountEmail, account.OpenaiRefreshToken, uniqueName)
-		//等待15秒
-		if err != nil {
-			log.Printf("error fetch shared token: %v \n", err)
-			log.Println("current account is: ", account.OpenaiAccountEmail)
-		}
-		shareTokens = append(shareTokens, token.TokenKey)
-		time.Sleep(15 * time.Second)
-
+		receiver.logf("openai account + openai sk keys size is greater than 100,do cut off to 100")
 	}
-
-	//add sk keys to shareTokens
-	shareTokens = append(shareTokens, openaiSkKeys...)
-
-	platform := fakeopen.NewAiFakeOpenPlatform()
-	//tokens with shared token
-
-	req := fakeopen.PooledTokenReq{
-		ShareTokens: shareTokens,
-		PoolToken:   "",
-	}
-	token, err := platform.RenewPooledToken(req)
-	if err != nil {
-		return token, errors.New("error renewing pool token: " + err.Error())
-	}
-	return token, nil
+	return receiver.BuildPool(append(RefreshTokenSources(renewSharedTokenRFTs), SkKeySources(openaiSkKeys)...), uniqueName)
 }
 
 // FetchAccessTokenBySessionToken
@@ -533,6 +474,5 @@ func (receiver *FakeOpenTokens) FetchMixedPooledTokenWithRefreshToken(renewShare
 //	@return fakeopen.SessionToken
 //	@return error
 func (receiver *FakeOpenTokens) FetchAccessTokenBySessionToken(openaiSessionToken string) (fakeopen.SessionToken, error) {
-	platform := fakeopen.NewAiFakeOpenPlatform()
-	return platform.GetAccessTokenBySessionToken(openaiSessionToken)
+	return receiver.conf().platform.GetAccessTokenBySessionToken(openaiSessionToken)
 }
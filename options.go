@@ -0,0 +1,287 @@
+package opaitokens
+
+import (
+	"context"
+	"github.com/fireinrain/opaitokens/auth"
+	"github.com/fireinrain/opaitokens/fakeopen"
+	"github.com/fireinrain/opaitokens/internal/transport"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Platform fakeopen 平台接口, 默认使用fakeopen.AiFakeOpenPlatform, 测试时可以替换为fake实现
+type Platform interface {
+	GetSharedToken(req fakeopen.SharedTokenReq) (fakeopen.SharedToken, error)
+	RevokeSharedToken(uniqueName string, accessToken string) (fakeopen.SharedToken, error)
+	RenewPooledToken(req fakeopen.PooledTokenReq) (fakeopen.PooledToken, error)
+	GetAccessTokenBySessionToken(sessionToken string) (fakeopen.SessionToken, error)
+}
+
+// Logger 日志输出, *log.Logger 实现了该接口
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Clock 时间来源, 批量操作之间的等待也通过Clock完成
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// Store 保存获取到的share token 以及refresh token, 如NewVaultStore
+type Store interface {
+	SaveSharedToken(result SharedTokenResult) error
+}
+
+// RefreshTokenLoader 可选接口, Scheduler 重启后从store 读取续期时轮换得到的refresh token
+type RefreshTokenLoader interface {
+	LoadRefreshToken(email string) (string, bool)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// sleep
+//
+//	@Description: 通过Clock 等待d, ctx 结束时提前返回
+//	@param ctx
+//	@param clock
+//	@param d
+//	@return error ctx 结束时返回ctx.Err()
+func sleep(ctx context.Context, clock Clock, d time.Duration) error {
+	if _, ok := clock.(realClock); ok {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		clock.Sleep(d)
+		close(done)
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+// config OpaiTokens 和 FakeOpenTokens 共用的依赖
+type config struct {
+	client     *http.Client
+	platform   Platform
+	authConfig auth.Config
+	logger     Logger
+	clock      Clock
+	store      Store
+	baseURL    string
+	mirrors    []string
+}
+
+// Option 构造OpaiTokens 和 FakeOpenTokens 时的选项
+type Option func(c *config)
+
+// WithHTTPClient
+//
+//	@Description: 所有请求复用该client, 未指定platform时也用于fakeopen
+//	@param client
+//	@return Option
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *config) {
+		c.client = client
+	}
+}
+
+// WithPlatform
+//
+//	@Description: 指定fakeopen 平台实现
+//	@param platform
+//	@return Option
+func WithPlatform(platform Platform) Option {
+	return func(c *config) {
+		c.platform = platform
+	}
+}
+
+// WithFakeOpenBaseURL
+//
+//	@Description: 使用自建的fakeopen 兼容服务或镜像, 同时用于登录时的preauth 和代理登录
+//	指定了WithPlatform 时platform 不使用该地址
+//	@param baseURL 如 https://pandora.example.com
+//	@param mirrors baseURL 不可用时依次尝试的地址
+//	@return Option
+func WithFakeOpenBaseURL(baseURL string, mirrors ...string) Option {
+	return func(c *config) {
+		c.baseURL = baseURL
+		c.mirrors = mirrors
+	}
+}
+
+// WithAuthConfig
+//
+//	@Description: 指定登录使用的配置, Client 为空时使用WithHTTPClient的client
+//	@param authConfig
+//	@return Option
+func WithAuthConfig(authConfig auth.Config) Option {
+	return func(c *config) {
+		c.authConfig = authConfig
+	}
+}
+
+// WithLogger
+//
+//	@Description: 指定日志输出
+//	@param logger
+//	@return Option
+func WithLogger(logger Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithClock
+//
+//	@Description: 指定时间来源, 未指定platform时也用于检查fakeopen 撤销后的fk 是否过期
+//	@param clock
+//	@return Option
+func WithClock(clock Clock) Option {
+	return func(c *config) {
+		c.clock = clock
+	}
+}
+
+// WithStore
+//
+//	@Description: 获取到share token 后保存到store, 仅FakeOpenTokens 使用
+//	@param store
+//	@return Option
+func WithStore(store Store) Option {
+	return func(c *config) {
+		c.store = store
+	}
+}
+
+// proxyClients 按代理地址复用的client, 避免每次登录都创建新的连接池
+var proxyClients sync.Map
+
+// withProxy
+//
+//	@Description: 返回使用proxyURL 的配置副本, 用于账号单独指定的代理, WithPlatform 指定的platform 保持不变
+//	@receiver c
+//	@param proxyURL
+//	@return *config
+//	@return error 代理地址无效
+func (c *config) withProxy(proxyURL string) (*config, error) {
+	var client *http.Client
+	if cached, ok := proxyClients.Load(proxyURL); ok {
+		client = cached.(*http.Client)
+	} else {
+		created, err := transport.NewClient(transport.Config{ProxyURL: proxyURL})
+		if err != nil {
+			return nil, err
+		}
+		cached, _ := proxyClients.LoadOrStore(proxyURL, created)
+		client = cached.(*http.Client)
+	}
+	proxied := *c
+	proxied.client = client
+	proxied.authConfig.Client = client
+	if platform, ok := c.platform.(*fakeopen.AiFakeOpenPlatform); ok {
+		copied := *platform
+		copied.Client = client
+		proxied.platform = &copied
+	}
+	return &proxied, nil
+}
+
+var (
+	defaultConfigOnce sync.Once
+	defaultConfig     *config
+)
+
+// newConfig
+//
+//	@Description: 应用选项并补全默认值
+//	@param opts
+//	@return *config
+func newConfig(opts []Option) *config {
+	c := &config{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.client == nil {
+		c.client = transport.Default()
+	}
+	if c.clock == nil {
+		c.clock = realClock{}
+	}
+	if c.platform == nil {
+		c.platform = &fakeopen.AiFakeOpenPlatform{
+			Client:  c.client,
+			BaseURL: c.baseURL,
+			Mirrors: c.mirrors,
+			Now:     c.clock.Now,
+		}
+	}
+	if c.authConfig.Client == nil {
+		c.authConfig.Client = c.client
+	}
+	if c.authConfig.BaseURL == "" {
+		c.authConfig.BaseURL = c.baseURL
+		c.authConfig.Mirrors = c.mirrors
+	}
+	if c.logger == nil {
+		c.logger = log.Default()
+	}
+	return c
+}
+
+// sharedDefaultConfig 零值OpaiTokens 和 FakeOpenTokens 使用的默认配置
+func sharedDefaultConfig() *config {
+	defaultConfigOnce.Do(func() {
+		defaultConfig = newConfig(nil)
+	})
+	return defaultConfig
+}
+
+// NewFakeOpenTokens
+//
+//	@Description: 创建FakeOpenTokens, 零值FakeOpenTokens 使用默认配置
+//	@param opts
+//	@return *FakeOpenTokens
+func NewFakeOpenTokens(opts ...Option) *FakeOpenTokens {
+	return &FakeOpenTokens{cfg: newConfig(opts)}
+}
+
+func (receiver *FakeOpenTokens) conf() *config {
+	if receiver.cfg == nil {
+		return sharedDefaultConfig()
+	}
+	return receiver.cfg
+}
+
+func (receiver *FakeOpenTokens) logf(format string, v ...interface{}) {
+	receiver.conf().logger.Printf(format, v...)
+}
+
+func (receiver *OpaiTokens) conf() *config {
+	if receiver.cfg == nil {
+		return sharedDefaultConfig()
+	}
+	return receiver.cfg
+}
@@ -0,0 +1,187 @@
+package opaitokens
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fireinrain/opaitokens/fakeopen"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakePlatform struct {
+	shareRequests  []fakeopen.SharedTokenReq
+	poolRequests   []fakeopen.PooledTokenReq
+	revokeRequests []string
+}
+
+func (p *fakePlatform) GetSharedToken(req fakeopen.SharedTokenReq) (fakeopen.SharedToken, error) {
+	p.shareRequests = append(p.shareRequests, req)
+	if req.AccessToken == "bad" {
+		return fakeopen.SharedToken{}, errors.New("invalid access token")
+	}
+	return fakeopen.SharedToken{TokenKey: "fk-" + req.AccessToken, UniqueName: req.UniqueName, ExpireAt: 100}, nil
+}
+
+func (p *fakePlatform) RevokeSharedToken(uniqueName string, accessToken string) (fakeopen.SharedToken, error) {
+	p.revokeRequests = append(p.revokeRequests, accessToken)
+	if accessToken == "bad" {
+		return fakeopen.SharedToken{}, fakeopen.ErrTokenNotRevoked
+	}
+	return fakeopen.SharedToken{TokenKey: "fk-" + accessToken, UniqueName: uniqueName}, nil
+}
+
+func (p *fakePlatform) RenewPooledToken(req fakeopen.PooledTokenReq) (fakeopen.PooledToken, error) {
+	p.poolRequests = append(p.poolRequests, req)
+	return fakeopen.PooledToken{Count: len(req.ShareTokens), PoolToken: "pk-fake"}, nil
+}
+
+func (p *fakePlatform) GetAccessTokenBySessionToken(sessionToken string) (fakeopen.SessionToken, error) {
+	return fakeopen.SessionToken{AccessToken: "at-" + sessionToken}, nil
+}
+
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.slept += d
+}
+
+type memoryStore []SharedTokenResult
+
+func (s *memoryStore) SaveSharedToken(result SharedTokenResult) error {
+	*s = append(*s, result)
+	return nil
+}
+
+func (s *memoryStore) LoadRefreshToken(email string) (string, bool) {
+	for i := len(*s) - 1; i >= 0; i-- {
+		if (*s)[i].Email == email && (*s)[i].RefreshToken != "" {
+			return (*s)[i].RefreshToken, true
+		}
+	}
+	return "", false
+}
+
+type bufferLogger struct {
+	lines []string
+}
+
+func (l *bufferLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestFakeOpenTokensWithOptions(t *testing.T) {
+	platform := &fakePlatform{}
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	store := &memoryStore{}
+	logger := &bufferLogger{}
+	tokens := NewFakeOpenTokens(WithPlatform(platform), WithClock(clock), WithStore(store), WithLogger(logger))
+
+	srcs := []CredentialSource{
+		AccessTokenSource{Email: "a@example.com", AccessToken: "a"},
+		AccessTokenSource{Email: "b@example.com", AccessToken: "bad"},
+		SessionTokenSource{Email: "c@example.com", SessionToken: "c"},
+	}
+	result, err := tokens.BuildPool(srcs, "fireinrain")
+	if err != nil {
+		t.Fatalf("build pool: %v", err)
+	}
+	if result.PoolToken != "pk-fake" || len(result.Members) != 2 || len(result.Excluded) != 1 {
+		t.Errorf("unexpected pool result: %+v", result)
+	}
+	if got := platform.poolRequests[0].ShareTokens; strings.Join(got, ",") != "fk-a,fk-at-c" {
+		t.Errorf("unexpected pool members: %v", got)
+	}
+	if clock.slept != 30*time.Second {
+		t.Errorf("sleep should go through the clock: %v", clock.slept)
+	}
+	if len(*store) != 2 || (*store)[0].Email != "a@example.com" {
+		t.Errorf("unexpected stored tokens: %+v", *store)
+	}
+	if len(logger.lines) == 0 {
+		t.Error("logs should go through the logger")
+	}
+}
+
+func TestWithFakeOpenBaseURL(t *testing.T) {
+	tokens := NewFakeOpenTokens(WithFakeOpenBaseURL("https://pandora.example.com", "https://mirror.example.com"))
+	platform, ok := tokens.conf().platform.(*fakeopen.AiFakeOpenPlatform)
+	if !ok {
+		t.Fatalf("platform = %T, want *fakeopen.AiFakeOpenPlatform", tokens.conf().platform)
+	}
+	if platform.BaseURL != "https://pandora.example.com" || len(platform.Mirrors) != 1 {
+		t.Fatalf("unexpected platform %+v", platform)
+	}
+	custom := &fakePlatform{}
+	tokens = NewFakeOpenTokens(WithPlatform(custom), WithFakeOpenBaseURL("https://pandora.example.com"))
+	if tokens.conf().platform != custom {
+		t.Fatalf("WithPlatform should take precedence")
+	}
+	// 默认platform 使用WithClock 指定的时间检查撤销结果
+	now := time.Unix(1700000000, 0)
+	tokens = NewFakeOpenTokens(WithClock(&fakeClock{now: now}))
+	platform = tokens.conf().platform.(*fakeopen.AiFakeOpenPlatform)
+	if platform.Now == nil || !platform.Now().Equal(now) {
+		t.Fatalf("default platform should use the configured clock")
+	}
+}
+
+func TestRevokeSharedTokens(t *testing.T) {
+	platform := &fakePlatform{}
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tokens := NewFakeOpenTokens(WithPlatform(platform), WithClock(clock), WithLogger(&bufferLogger{}))
+
+	srcs := []CredentialSource{
+		AccessTokenSource{Email: "a@example.com", AccessToken: "a"},
+		AccessTokenSource{Email: "b@example.com", AccessToken: "bad"},
+		SessionTokenSource{Email: "c@example.com", SessionToken: "c"},
+	}
+	result, err := tokens.RevokeSharedTokens(srcs, "fireinrain")
+	if !errors.Is(err, fakeopen.ErrTokenNotRevoked) {
+		t.Fatalf("err = %v, want ErrTokenNotRevoked", err)
+	}
+	if result.RevokeCount != 2 || result.RevokeSuccess || strings.Join(result.Failed, ",") != "b@example.com" {
+		t.Errorf("unexpected revoke result: %+v", result)
+	}
+	if strings.Join(platform.revokeRequests, ",") != "a,bad,at-c" {
+		t.Errorf("unexpected revoke requests: %v", platform.revokeRequests)
+	}
+	if clock.slept != 30*time.Second {
+		t.Errorf("unexpected sleep: %v", clock.slept)
+	}
+}
+
+func TestRenewSharedTokensAndWrappedSkKey(t *testing.T) {
+	platform := &fakePlatform{}
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	tokens := NewFakeOpenTokens(WithPlatform(platform), WithClock(clock), WithLogger(&bufferLogger{}))
+
+	srcs := []CredentialSource{
+		AccessTokenSource{Email: "a@example.com", AccessToken: "a"},
+		AccessTokenSource{Email: "b@example.com", AccessToken: "b"},
+	}
+	result, err := tokens.RenewSharedTokens(srcs, "fireinrain")
+	if err != nil || !result.RenewSuccess || result.RenewCount != 2 {
+		t.Fatalf("unexpected renew result: %+v %v", result, err)
+	}
+	if clock.slept != 15*time.Second {
+		t.Errorf("no sleep expected after the last source: %v", clock.slept)
+	}
+
+	skKey := "sk-" + strings.Repeat("a", 48)
+	wrapped := WithShareTokenOptions(SkKeySource{Key: skKey}, ShareTokenOptions{ExpiresIn: 3600})
+	pool, err := tokens.BuildPool([]CredentialSource{srcs[0], wrapped}, "fireinrain")
+	if err != nil {
+		t.Fatalf("build pool: %v", err)
+	}
+	if got := platform.poolRequests[0].ShareTokens; strings.Join(got, ",") != "fk-a,"+skKey || len(pool.Excluded) != 0 {
+		t.Errorf("wrapped sk key should join the pool directly: %v %+v", got, pool.Excluded)
+	}
+}
@@ -0,0 +1,238 @@
+package opaitokens
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fireinrain/opaitokens/fakeopen"
+	"regexp"
+	"time"
+)
+
+// MembershipMode pool成员获取失败时的处理方式
+type MembershipMode int
+
+const (
+	// SkipFailedMembers 跳过失败的成员,使用剩余成员更新pool(默认)
+	SkipFailedMembers MembershipMode = iota
+	// FailOnAnyMember 任意成员失败则整个pool失败
+	FailOnAnyMember
+	// RequireMinSuccessRatio 成功比例不低于MinSuccessRatio时才更新pool
+	RequireMinSuccessRatio
+)
+
+// MembershipPolicy pool成员策略
+type MembershipPolicy struct {
+	Mode MembershipMode `json:"mode"`
+	//成功比例下限 取值(0,1] 仅在RequireMinSuccessRatio模式下生效
+	MinSuccessRatio float64 `json:"min_success_ratio"`
+}
+
+// ExcludedMember 未加入pool的成员及原因
+type ExcludedMember struct {
+	Member string `json:"member"`
+	Reason string `json:"reason"`
+}
+
+// PooledTokenResult pool token 以及成员情况
+type PooledTokenResult struct {
+	fakeopen.PooledToken
+	Members  []string         `json:"members"`
+	Excluded []ExcludedMember `json:"excluded"`
+	//获取share token 时得到的refresh token 和id token
+	Credentials []AccountCredential `json:"credentials,omitempty"`
+}
+
+var shareTokenPattern = regexp.MustCompile(`^fk-[0-9A-Za-z_-]+$`)
+
+// sk key 如 sk-xxxx(48位) 或 sk-proj-xxxx
+var skKeyPattern = regexp.MustCompile(`^sk-[0-9A-Za-z_-]{20,}$`)
+
+// poolCandidate 待加入pool的成员, fetch 用于获取该成员的share token
+type poolCandidate struct {
+	member string
+	fetch  func() (SharedTokenResult, error)
+}
+
+type poolMember struct {
+	member string
+	key    string
+}
+
+// check
+//
+//	@Description: 根据策略检查成员获取结果
+//	@receiver p
+//	@param total
+//	@param succeeded
+//	@return error
+func (p MembershipPolicy) check(total int, succeeded int) error {
+	switch p.Mode {
+	case FailOnAnyMember:
+		if succeeded < total {
+			return fmt.Errorf("%d of %d pool members failed", total-succeeded, total)
+		}
+	case RequireMinSuccessRatio:
+		if p.MinSuccessRatio <= 0 || p.MinSuccessRatio > 1 {
+			return fmt.Errorf("invalid min success ratio: %v", p.MinSuccessRatio)
+		}
+		if total > 0 && float64(succeeded)/float64(total) < p.MinSuccessRatio {
+			return fmt.Errorf("pool members success ratio %d/%d is below %v", succeeded, total, p.MinSuccessRatio)
+		}
+	}
+	return nil
+}
+
+// preparePoolMembers
+//
+//	@Description: 去重并检查key格式, 返回可用成员以及被排除的成员
+//	@param members
+//	@return []poolMember
+//	@return []ExcludedMember
+func preparePoolMembers(members []poolMember) ([]poolMember, []ExcludedMember) {
+	var kept []poolMember
+	var excluded []ExcludedMember
+	seen := make(map[string]string)
+	for _, m := range members {
+		if m.key == "" {
+			excluded = append(excluded, ExcludedMember{Member: m.member, Reason: "empty token key"})
+			continue
+		}
+		if !validPoolKey(m.key) {
+			excluded = append(excluded, ExcludedMember{Member: m.member, Reason: "invalid token format"})
+			continue
+		}
+		if owner, ok := seen[m.key]; ok {
+			excluded = append(excluded, ExcludedMember{Member: m.member, Reason: "duplicate of " + owner})
+			continue
+		}
+		seen[m.key] = m.member
+		kept = append(kept, m)
+	}
+	return kept, excluded
+}
+
+// validPoolKey
+//
+//	@Description: pool成员必须是fk或者sk
+//	@param key
+//	@return bool
+func validPoolKey(key string) bool {
+	return shareTokenPattern.MatchString(key) || skKeyPattern.MatchString(key)
+}
+
+// maskKey
+//
+//	@Description: 隐藏key的中间部分, 用于日志和结果展示
+//	@param key
+//	@return string
+func maskKey(key string) string {
+	if len(key) <= 10 {
+		return key
+	}
+	return key[:5] + "..." + key[len(key)-4:]
+}
+
+// buildPool
+//
+//	@Description: 获取所有成员的share token, 按策略过滤后注册pool token
+//	@receiver receiver
+//	@param candidates
+//	@param skKeys
+//	@param poolToken 已存在的pk, 为空时注册新的pool token
+//	@return PooledTokenResult
+//	@return error
+func (receiver *FakeOpenTokens) buildPool(candidates []poolCandidate, skKeys []string, poolToken string) (PooledTokenResult, error) {
+	result := PooledTokenResult{}
+	var members []poolMember
+	fetched := 0
+	skKeys, skExcluded := receiver.ValidateSkKeys(skKeys)
+	result.Excluded = append(result.Excluded, skExcluded...)
+	if len(skKeys) > PooledTokenAccountsLimit {
+		for _, key := range skKeys[PooledTokenAccountsLimit:] {
+			result.Excluded = append(result.Excluded, ExcludedMember{Member: maskKey(key), Reason: "pool size limit exceeded"})
+		}
+		skKeys = skKeys[:PooledTokenAccountsLimit]
+	}
+	limit := PooledTokenAccountsLimit - len(skKeys)
+	for index, candidate := range candidates {
+		if index >= limit {
+			result.Excluded = append(result.Excluded, ExcludedMember{Member: candidate.member, Reason: "pool size limit exceeded"})
+			continue
+		}
+		receiver.logf("fetching pooled token progress...%v/%v.", index+1, len(candidates))
+		token, err := candidate.fetch()
+		if err != nil {
+			receiver.logf("error fetch shared token for %v: %v", candidate.member, err)
+			result.Excluded = append(result.Excluded, ExcludedMember{Member: candidate.member, Reason: err.Error()})
+			receiver.notifyAccountFailed(candidate.member, err)
+		} else {
+			members = append(members, poolMember{member: candidate.member, key: token.TokenKey})
+			if credential, ok := token.Credential(); ok {
+				result.Credentials = append(result.Credentials, credential)
+			}
+		}
+		fetched += 1
+		//等待15秒, 最后一个成员之后不再等待
+		if index < len(candidates)-1 && index < limit-1 {
+			receiver.conf().clock.Sleep(15 * time.Second)
+		}
+	}
+	//add sk keys to pool members
+	for _, key := range skKeys {
+		members = append(members, poolMember{member: maskKey(key), key: key})
+	}
+
+	kept, excluded := preparePoolMembers(members)
+	result.Excluded = append(result.Excluded, excluded...)
+	total := fetched + len(skKeys) + len(skExcluded)
+	receiver.checkPoolDegraded(poolToken, len(kept), total)
+	if err := receiver.MembershipPolicy.check(total, len(kept)); err != nil {
+		err = errors.New("pool membership policy not satisfied: " + err.Error())
+		receiver.notifyPoolUpdateFailed(poolToken, err)
+		return result, err
+	}
+	if len(kept) == 0 {
+		err := errors.New("no valid member for pool")
+		receiver.notifyPoolUpdateFailed(poolToken, err)
+		return result, err
+	}
+
+	var shareTokens []string
+	for _, m := range kept {
+		shareTokens = append(shareTokens, m.key)
+		result.Members = append(result.Members, m.member)
+	}
+	token, err := receiver.renewPool(poolToken, shareTokens)
+	if err != nil {
+		return result, err
+	}
+	result.PooledToken = token
+	return result, nil
+}
+
+// renewPool
+//
+//	@Description: 更新pool成员, poolToken不为空时要求返回的pk保持不变
+//	@receiver receiver
+//	@param poolToken
+//	@param shareTokens
+//	@return fakeopen.PooledToken
+//	@return error
+func (receiver *FakeOpenTokens) renewPool(poolToken string, shareTokens []string) (fakeopen.PooledToken, error) {
+	req := fakeopen.PooledTokenReq{
+		ShareTokens: shareTokens,
+		PoolToken:   poolToken,
+	}
+	token, err := receiver.conf().platform.RenewPooledToken(req)
+	if err != nil {
+		err = fmt.Errorf("error renewing pool token: %w", err)
+		receiver.notifyPoolUpdateFailed(poolToken, err)
+		return token, err
+	}
+	if poolToken != "" && token.PoolToken != poolToken {
+		err = fmt.Errorf("pool token changed from %s to %s", maskKey(poolToken), maskKey(token.PoolToken))
+		receiver.notifyPoolUpdateFailed(poolToken, err)
+		return token, err
+	}
+	return token, nil
+}
@@ -0,0 +1,41 @@
+package opaitokens
+
+import (
+	"testing"
+)
+
+func TestPreparePoolMembers(t *testing.T) {
+	members := []poolMember{
+		{member: "a@example.com", key: "fk-aaaa"},
+		{member: "b@example.com", key: ""},
+		{member: "c@example.com", key: "fk-aaaa"},
+		{member: "d@example.com", key: "not a token"},
+		{member: "sk-12...abcd", key: "sk-1234567890abcdefghijabcd"},
+	}
+	kept, excluded := preparePoolMembers(members)
+	if len(kept) != 2 {
+		t.Fatalf("expected 2 kept members, got %v", kept)
+	}
+	if len(excluded) != 3 {
+		t.Fatalf("expected 3 excluded members, got %v", excluded)
+	}
+	if excluded[1].Reason != "duplicate of a@example.com" {
+		t.Errorf("unexpected reason: %s", excluded[1].Reason)
+	}
+}
+
+func TestMembershipPolicyCheck(t *testing.T) {
+	if err := (MembershipPolicy{}).check(10, 1); err != nil {
+		t.Errorf("skip failed policy should not fail: %v", err)
+	}
+	if err := (MembershipPolicy{Mode: FailOnAnyMember}).check(10, 9); err == nil {
+		t.Error("fail on any member policy should fail")
+	}
+	policy := MembershipPolicy{Mode: RequireMinSuccessRatio, MinSuccessRatio: 0.8}
+	if err := policy.check(10, 8); err != nil {
+		t.Errorf("ratio 0.8 should pass: %v", err)
+	}
+	if err := policy.check(10, 7); err == nil {
+		t.Error("ratio 0.7 should fail")
+	}
+}
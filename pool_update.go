@@ -0,0 +1,133 @@
+package opaitokens
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// PoolMembers 已存在的pool token及其当前成员
+type PoolMembers struct {
+	PoolToken   string   `json:"pool_token"`
+	ShareTokens []string `json:"share_tokens"`
+}
+
+// PoolDiff 成员变化
+type PoolDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// Empty
+//
+//	@Description: 成员是否没有变化
+//	@receiver d
+//	@return bool
+func (d PoolDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+// DiffPoolMembers
+//
+//	@Description: 计算当前成员与期望成员的差异
+//	@param current
+//	@param desired
+//	@return PoolDiff
+func DiffPoolMembers(current []string, desired []string) PoolDiff {
+	diff := PoolDiff{}
+	currentSet := make(map[string]bool)
+	for _, token := range current {
+		currentSet[token] = true
+	}
+	desiredSet := make(map[string]bool)
+	for _, token := range desired {
+		if !currentSet[token] && !desiredSet[token] {
+			diff.Added = append(diff.Added, token)
+		}
+		desiredSet[token] = true
+	}
+	for token := range currentSet {
+		if !desiredSet[token] {
+			diff.Removed = append(diff.Removed, token)
+		}
+	}
+	sort.Strings(diff.Removed)
+	return diff
+}
+
+// AddToPool
+//
+//	@Description: 向已有pool中添加share token, pk保持不变
+//	@receiver receiver
+//	@param pool
+//	@param shareTokens
+//	@return PoolMembers
+//	@return PoolDiff
+//	@return error
+func (receiver *FakeOpenTokens) AddToPool(pool PoolMembers, shareTokens ...string) (PoolMembers, PoolDiff, error) {
+	desired := append(append([]string{}, pool.ShareTokens...), shareTokens...)
+	return receiver.ReplacePoolMembers(pool, desired)
+}
+
+// RemoveFromPool
+//
+//	@Description: 从已有pool中移除share token, pk保持不变
+//	@receiver receiver
+//	@param pool
+//	@param shareTokens
+//	@return PoolMembers
+//	@return PoolDiff
+//	@return error
+func (receiver *FakeOpenTokens) RemoveFromPool(pool PoolMembers, shareTokens ...string) (PoolMembers, PoolDiff, error) {
+	removed := make(map[string]bool)
+	for _, token := range shareTokens {
+		removed[token] = true
+	}
+	var desired []string
+	for _, token := range pool.ShareTokens {
+		if !removed[token] {
+			desired = append(desired, token)
+		}
+	}
+	return receiver.ReplacePoolMembers(pool, desired)
+}
+
+// ReplacePoolMembers
+//
+//	@Description: 将pool成员替换为desired, 成员没有变化时不请求平台
+//	pool.PoolToken为空时注册新的pool token
+//	@receiver receiver
+//	@param pool
+//	@param desired
+//	@return PoolMembers
+//	@return PoolDiff
+//	@return error
+func (receiver *FakeOpenTokens) ReplacePoolMembers(pool PoolMembers, desired []string) (PoolMembers, PoolDiff, error) {
+	var shareTokens []string
+	seen := make(map[string]bool)
+	for _, token := range desired {
+		if !validPoolKey(token) {
+			return pool, PoolDiff{}, fmt.Errorf("invalid pool member: %s", maskKey(token))
+		}
+		if !seen[token] {
+			seen[token] = true
+			shareTokens = append(shareTokens, token)
+		}
+	}
+
+	diff := DiffPoolMembers(pool.ShareTokens, shareTokens)
+	if diff.Empty() && pool.PoolToken != "" {
+		return pool, diff, nil
+	}
+	if len(shareTokens) == 0 {
+		return pool, diff, errors.New("pool must keep at least one member")
+	}
+	if len(shareTokens) > PooledTokenAccountsLimit {
+		return pool, diff, fmt.Errorf("pool members %d exceed the limit %d", len(shareTokens), PooledTokenAccountsLimit)
+	}
+	token, err := receiver.renewPool(pool.PoolToken, shareTokens)
+	if err != nil {
+		return pool, diff, err
+	}
+	return PoolMembers{PoolToken: token.PoolToken, ShareTokens: shareTokens}, diff, nil
+}
@@ -0,0 +1,33 @@
+package opaitokens
+
+import (
+	"testing"
+)
+
+func TestDiffPoolMembers(t *testing.T) {
+	diff := DiffPoolMembers([]string{"fk-a", "fk-b", "fk-c"}, []string{"fk-b", "fk-d", "fk-d", "fk-a"})
+	if len(diff.Added) != 1 || diff.Added[0] != "fk-d" {
+		t.Errorf("unexpected added: %v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0] != "fk-c" {
+		t.Errorf("unexpected removed: %v", diff.Removed)
+	}
+	if !DiffPoolMembers([]string{"fk-a"}, []string{"fk-a"}).Empty() {
+		t.Error("expected empty diff")
+	}
+}
+
+func TestReplacePoolMembersUnchanged(t *testing.T) {
+	receiver := &FakeOpenTokens{}
+	pool := PoolMembers{PoolToken: "pk-xxxx", ShareTokens: []string{"fk-a", "fk-b"}}
+	updated, diff, err := receiver.RemoveFromPool(pool, "fk-c")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !diff.Empty() || updated.PoolToken != pool.PoolToken {
+		t.Errorf("pool should stay unchanged: %v %v", updated, diff)
+	}
+	if _, _, err := receiver.AddToPool(pool, "bad token"); err == nil {
+		t.Error("expected error for invalid member")
+	}
+}
@@ -0,0 +1,468 @@
+package opaitokens
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/fireinrain/opaitokens/fakeopen"
+	"github.com/fireinrain/opaitokens/utils"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SchedulerOptions 续期调度选项, 零值使用默认值
+type SchedulerOptions struct {
+	UniqueName string
+	//到期前多久续期 默认3天
+	LeadTime time.Duration
+	//随机提前的最大时间 避免所有账号同时续期 默认1小时
+	Jitter time.Duration
+	//失败后的重试间隔 每次失败翻倍 默认1分钟, 最大6小时
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	//两次续期请求之间的间隔 默认15秒
+	Spacing time.Duration
+	//检查间隔上限 默认1分钟
+	TickInterval time.Duration
+	//调度状态保存路径 为空时不保存
+	StatePath string
+	//share token 到期前多久发送即将过期通知 无论是否续期 默认同LeadTime
+	ExpiryAlert time.Duration
+}
+
+// ScheduleEntry 单个账号的调度状态
+type ScheduleEntry struct {
+	Email               string `json:"email"`
+	ShareToken          string `json:"share_token"`
+	ShareTokenExpireAt  int64  `json:"share_token_expire_at"`
+	AccessTokenExpireAt int64  `json:"access_token_expire_at"`
+	NextRunAt           int64  `json:"next_run_at"`
+	LastRenewAt         int64  `json:"last_renew_at"`
+	Failures            int    `json:"failures"`
+	LastError           string `json:"last_error,omitempty"`
+	//refresh token 已失效
+	RefreshTokenRevoked bool `json:"refresh_token_revoked,omitempty"`
+	//没有其他可用凭证, 停止续期直到重新添加账号
+	Disabled bool `json:"disabled,omitempty"`
+	//添加账号时凭证的摘要, 重启后用于判断凭证是否更新
+	CredentialHash string `json:"credential_hash,omitempty"`
+	//已发送即将过期通知的share token 过期时间, 同一过期时间只通知一次
+	AlertedExpireAt int64 `json:"alerted_expire_at,omitempty"`
+}
+
+// renewal 一次续期的结果
+type renewal struct {
+	shareToken          fakeopen.SharedToken
+	accessTokenExpireAt int64
+	//密码登录时获取到的refresh token, 之后的续期改用refresh token
+	refreshToken string
+	idToken      string
+}
+
+// Scheduler 在share token 和 access token 到期之前自动续期
+type Scheduler struct {
+	tokens   *FakeOpenTokens
+	opts     SchedulerOptions
+	mu       sync.Mutex
+	accounts map[string]OpenaiAccount
+	entries  map[string]*ScheduleEntry
+	now      func() time.Time
+	renew    func(account OpenaiAccount) (renewal, error)
+}
+
+// NewScheduler
+//
+//	@Description: 创建续期调度器, 设置StatePath时会读取已保存的调度状态
+//	@param tokens
+//	@param accounts
+//	@param opts
+//	@return *Scheduler
+//	@return error
+func NewScheduler(tokens *FakeOpenTokens, accounts []OpenaiAccount, opts SchedulerOptions) (*Scheduler, error) {
+	if opts.UniqueName == "" {
+		return nil, errors.New("unique name of scheduler is empty")
+	}
+	if opts.LeadTime <= 0 {
+		opts.LeadTime = DefaultRenewBefore
+	}
+	if opts.Jitter < 0 {
+		opts.Jitter = 0
+	} else if opts.Jitter == 0 {
+		opts.Jitter = time.Hour
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = time.Minute
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 6 * time.Hour
+	}
+	if opts.Spacing <= 0 {
+		opts.Spacing = 15 * time.Second
+	}
+	if opts.TickInterval <= 0 {
+		opts.TickInterval = time.Minute
+	}
+	if opts.ExpiryAlert <= 0 {
+		opts.ExpiryAlert = opts.LeadTime
+	}
+	s := &Scheduler{
+		tokens:   tokens,
+		opts:     opts,
+		accounts: make(map[string]OpenaiAccount),
+		entries:  make(map[string]*ScheduleEntry),
+		now:      tokens.conf().clock.Now,
+	}
+	s.renew = s.renewAccount
+	if opts.StatePath != "" {
+		if err := s.load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	for _, account := range accounts {
+		s.AddAccount(account)
+	}
+	//丢弃已保存但不再调度的账号
+	for key := range s.entries {
+		if _, ok := s.accounts[key]; !ok {
+			delete(s.entries, key)
+		}
+	}
+	return s, nil
+}
+
+// AddAccount
+//
+//	@Description: 添加或更新账号, 新账号会在下一次检查时续期
+//	调度状态中不保存refresh token, 凭证与上次添加时(包括保存的调度状态)相同且store 实现了RefreshTokenLoader 时
+//	继续使用store 中续期轮换得到的refresh token
+//	@receiver s
+//	@param account
+func (s *Scheduler) AddAccount(account OpenaiAccount) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.ToLower(account.Email)
+	hash := credentialHash(account)
+	entry, ok := s.entries[key]
+	if !ok {
+		s.entries[key] = &ScheduleEntry{Email: account.Email, NextRunAt: s.now().Unix(), CredentialHash: hash}
+		s.accounts[key] = account
+		return
+	}
+	if entry.CredentialHash != hash {
+		//更新了凭证的账号重新开始续期
+		entry.CredentialHash = hash
+		if entry.Disabled {
+			entry.Disabled = false
+			entry.RefreshTokenRevoked = false
+			entry.Failures = 0
+			entry.NextRunAt = s.now().Unix()
+		}
+	} else if entry.RefreshTokenRevoked {
+		account.RefreshToken = ""
+	} else if loader, ok := s.tokens.conf().store.(RefreshTokenLoader); ok {
+		if refreshToken, ok := loader.LoadRefreshToken(account.Email); ok && refreshToken != "" {
+			account.RefreshToken = refreshToken
+		}
+	}
+	s.accounts[key] = account
+}
+
+// credentialHash 账号凭证的摘要, 调度状态中不保存密码
+func credentialHash(account OpenaiAccount) string {
+	sum := sha256.Sum256([]byte(account.RefreshToken + "\n" + account.Password + "\n" + account.SessionToken))
+	return hex.EncodeToString(sum[:16])
+}
+
+// RemoveAccount
+//
+//	@Description: 停止续期该账号
+//	@receiver s
+//	@param email
+func (s *Scheduler) RemoveAccount(email string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.ToLower(email)
+	delete(s.accounts, key)
+	delete(s.entries, key)
+}
+
+// NextRun
+//
+//	@Description: 账号下一次续期的时间
+//	@receiver s
+//	@param email
+//	@return time.Time
+//	@return bool
+func (s *Scheduler) NextRun(email string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[strings.ToLower(email)]
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(entry.NextRunAt, 0), true
+}
+
+// Entries
+//
+//	@Description: 所有账号的调度状态, 按下一次续期时间排序
+//	@receiver s
+//	@return []ScheduleEntry
+func (s *Scheduler) Entries() []ScheduleEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []ScheduleEntry
+	for _, entry := range s.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].NextRunAt == entries[j].NextRunAt {
+			return entries[i].Email < entries[j].Email
+		}
+		return entries[i].NextRunAt < entries[j].NextRunAt
+	})
+	return entries
+}
+
+// Run
+//
+//	@Description: 持续运行直到ctx结束
+//	@receiver s
+//	@param ctx
+//	@return error
+func (s *Scheduler) Run(ctx context.Context) error {
+	for {
+		if err := s.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			s.tokens.logf("scheduler run failed: %v", err)
+		}
+		if err := sleep(ctx, s.tokens.conf().clock, s.nextWait()); err != nil {
+			return err
+		}
+	}
+}
+
+// nextWait 距离下一个未停用账号续期的时间, 不超过TickInterval 且不小于1秒
+func (s *Scheduler) nextWait() time.Duration {
+	wait := s.opts.TickInterval
+	now := s.now()
+	for _, entry := range s.Entries() {
+		if entry.Disabled {
+			continue
+		}
+		if d := time.Unix(entry.NextRunAt, 0).Sub(now); d < wait {
+			wait = d
+		}
+		//Entries 按NextRunAt 排序
+		break
+	}
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
+
+// RunOnce
+//
+//	@Description: 续期所有到期的账号, 对即将过期的share token 发送通知并保存调度状态
+//	@receiver s
+//	@param ctx
+//	@return error
+func (s *Scheduler) RunOnce(ctx context.Context) error {
+	var due []OpenaiAccount
+	now := s.now().Unix()
+	s.mu.Lock()
+	for key, entry := range s.entries {
+		if !entry.Disabled && entry.NextRunAt <= now {
+			due = append(due, s.accounts[key])
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(due, func(i, j int) bool { return due[i].Email < due[j].Email })
+
+	for index, account := range due {
+		if index > 0 {
+			if err := sleep(ctx, s.tokens.conf().clock, s.opts.Spacing); err != nil {
+				return err
+			}
+		}
+		s.tokens.logf("scheduled renew progress...%v/%v.", index+1, len(due))
+		result, err := s.renew(account)
+		s.record(account.Email, result, err)
+	}
+	s.alertExpiring()
+	if s.opts.StatePath != "" {
+		return s.save()
+	}
+	return nil
+}
+
+// record 记录续期结果并计算下一次续期时间
+func (s *Scheduler) record(email string, result renewal, err error) {
+	s.mu.Lock()
+	entry, ok := s.entries[strings.ToLower(email)]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	now := s.now()
+	if err != nil {
+		s.tokens.logf("scheduled renew failed for %v: %v", email, err)
+		entry.Failures += 1
+		entry.LastError = err.Error()
+		entry.NextRunAt = now.Add(s.backoff(entry.Failures)).Unix()
+		if IsRefreshTokenRevoked(err) {
+			//失效的refresh token 不再重试, 有密码或session token 时立即改用其他凭证
+			entry.RefreshTokenRevoked = true
+			account := s.accounts[strings.ToLower(email)]
+			account.RefreshToken = ""
+			s.accounts[strings.ToLower(email)] = account
+			if account.Password != "" || account.SessionToken != "" {
+				entry.NextRunAt = now.Unix()
+			} else {
+				entry.Disabled = true
+			}
+		}
+		s.mu.Unlock()
+		s.tokens.notifyAccountFailed(email, err)
+		return
+	}
+	account := s.accounts[strings.ToLower(email)]
+	if result.refreshToken != "" {
+		account.RefreshToken = result.refreshToken
+		s.accounts[strings.ToLower(email)] = account
+	}
+	entry.Failures = 0
+	entry.LastError = ""
+	entry.RefreshTokenRevoked = false
+	entry.LastRenewAt = now.Unix()
+	entry.ShareToken = result.shareToken.TokenKey
+	entry.ShareTokenExpireAt = result.shareToken.ExpireAt
+	entry.AccessTokenExpireAt = result.accessTokenExpireAt
+	entry.NextRunAt = s.nextRun(entry, now).Unix()
+	s.mu.Unlock()
+	if store := s.tokens.conf().store; store != nil {
+		stored := SharedTokenResult{SharedToken: result.shareToken, Email: email, RefreshToken: account.RefreshToken, IDToken: result.idToken}
+		if err := store.SaveSharedToken(stored); err != nil {
+			s.tokens.logf("save shared token for %v failed: %v", email, err)
+		}
+	}
+}
+
+// alertExpiring 对过期时间在ExpiryAlert 之内的share token 发送一次即将过期通知, 附带最近一次续期错误
+func (s *Scheduler) alertExpiring() {
+	type alert struct {
+		email     string
+		expireAt  int64
+		lastError string
+	}
+	var alerts []alert
+	now := s.now()
+	s.mu.Lock()
+	for _, entry := range s.entries {
+		expireAt := entry.ShareTokenExpireAt
+		if expireAt <= 0 || entry.AlertedExpireAt == expireAt || time.Unix(expireAt, 0).Sub(now) > s.opts.ExpiryAlert {
+			continue
+		}
+		entry.AlertedExpireAt = expireAt
+		alerts = append(alerts, alert{email: entry.Email, expireAt: expireAt, lastError: entry.LastError})
+	}
+	s.mu.Unlock()
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].email < alerts[j].email })
+	for _, a := range alerts {
+		var err error
+		if a.lastError != "" {
+			err = errors.New(a.lastError)
+		}
+		s.tokens.notifyTokenExpiring(a.email, a.expireAt, err)
+	}
+}
+
+// backoff 失败次数对应的重试间隔
+func (s *Scheduler) backoff(failures int) time.Duration {
+	d := s.opts.MinBackoff
+	for i := 1; i < failures && d < s.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > s.opts.MaxBackoff {
+		d = s.opts.MaxBackoff
+	}
+	return d
+}
+
+// nextRun 取share token 和 access token 中较早的过期时间, 提前LeadTime并随机提前不超过Jitter
+func (s *Scheduler) nextRun(entry *ScheduleEntry, now time.Time) time.Time {
+	expireAt := entry.ShareTokenExpireAt
+	if entry.AccessTokenExpireAt > 0 && (expireAt <= 0 || entry.AccessTokenExpireAt < expireAt) {
+		expireAt = entry.AccessTokenExpireAt
+	}
+	if expireAt <= 0 {
+		return now.Add(s.opts.MaxBackoff)
+	}
+	next := time.Unix(expireAt, 0).Add(-s.opts.LeadTime)
+	if s.opts.Jitter > 0 {
+		next = next.Add(-time.Duration(rand.Int63n(int64(s.opts.Jitter))))
+	}
+	if next.Before(now) {
+		return now
+	}
+	return next
+}
+
+// renewAccount 默认的续期方式, 有refresh token时优先使用refresh token
+func (s *Scheduler) renewAccount(account OpenaiAccount) (renewal, error) {
+	result := renewal{}
+	token, err := s.tokens.sourceToken(account)
+	if err != nil {
+		return result, err
+	}
+	if exp, err := utils.JwtExpireAt(token.AccessToken); err == nil {
+		result.accessTokenExpireAt = exp
+	}
+	if token.RefreshToken != account.RefreshToken {
+		result.refreshToken = token.RefreshToken
+	}
+	result.idToken = token.IDToken
+	result.shareToken, err = s.tokens.registerSharedToken(token.AccessToken, s.opts.UniqueName, s.tokens.shareTokenOptionsFor(account))
+	return result, err
+}
+
+type schedulerState struct {
+	Entries []ScheduleEntry `json:"entries"`
+}
+
+func (s *Scheduler) load() error {
+	data, err := os.ReadFile(s.opts.StatePath)
+	if err != nil {
+		return err
+	}
+	state := schedulerState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return errors.New("unmarshal scheduler state failed: " + err.Error())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range state.Entries {
+		entry := entry
+		s.entries[strings.ToLower(entry.Email)] = &entry
+	}
+	return nil
+}
+
+func (s *Scheduler) save() error {
+	state := schedulerState{Entries: s.Entries()}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = utils.WriteFileAtomic(s.opts.StatePath, data, 0600)
+	if err != nil {
+		return errors.New("save scheduler state failed: " + err.Error())
+	}
+	return nil
+}
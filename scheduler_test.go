@@ -0,0 +1,265 @@
+package opaitokens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fireinrain/opaitokens/fakeopen"
+	"github.com/fireinrain/opaitokens/notify"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRenewAndBackoff(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	statePath := filepath.Join(t.TempDir(), "schedule.json")
+	accounts := []OpenaiAccount{
+		{Email: "a@example.com", Password: "pass"},
+		{Email: "b@example.com", Password: "pass"},
+	}
+	opts := SchedulerOptions{
+		UniqueName: "fireinrain",
+		LeadTime:   24 * time.Hour,
+		Jitter:     -1,
+		Spacing:    time.Millisecond,
+		StatePath:  statePath,
+	}
+	store := &memoryStore{}
+	tokens := NewFakeOpenTokens(WithStore(store), WithLogger(&bufferLogger{}))
+	scheduler, err := NewScheduler(tokens, nil, opts)
+	if err != nil {
+		t.Fatalf("new scheduler: %v", err)
+	}
+	scheduler.now = func() time.Time { return now }
+	for _, account := range accounts {
+		scheduler.AddAccount(account)
+	}
+	scheduler.renew = func(account OpenaiAccount) (renewal, error) {
+		if account.Email == "b@example.com" {
+			return renewal{}, errors.New("login failed")
+		}
+		return renewal{
+			shareToken:          fakeopen.SharedToken{TokenKey: "fk-a", ExpireAt: now.Add(14 * 24 * time.Hour).Unix()},
+			accessTokenExpireAt: now.Add(10 * 24 * time.Hour).Unix(),
+			refreshToken:        "rt-a",
+		}, nil
+	}
+	if err := scheduler.RunOnce(context.Background()); err != nil {
+		t.Fatalf("run once: %v", err)
+	}
+	next, _ := scheduler.NextRun("a@example.com")
+	if want := now.Add(9 * 24 * time.Hour); !next.Equal(want) {
+		t.Errorf("unexpected next run for a: %v, want %v", next, want)
+	}
+	next, _ = scheduler.NextRun("b@example.com")
+	if want := now.Add(time.Minute); !next.Equal(want) {
+		t.Errorf("unexpected next run for b: %v, want %v", next, want)
+	}
+	if scheduler.accounts["a@example.com"].RefreshToken != "rt-a" {
+		t.Error("account a should switch to refresh token")
+	}
+
+	if data, _ := os.ReadFile(statePath); strings.Contains(string(data), "rt-a") {
+		t.Error("refresh token should not be written to the scheduler state")
+	}
+
+	// 重新加载后保持调度状态, refresh token 从store 读取
+	reloaded, err := NewScheduler(tokens, accounts, opts)
+	if err != nil {
+		t.Fatalf("reload scheduler: %v", err)
+	}
+	entries := reloaded.Entries()
+	if len(entries) != 2 || entries[0].Email != "b@example.com" || entries[0].Failures != 1 {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+	if reloaded.accounts["a@example.com"].RefreshToken != "rt-a" {
+		t.Error("rotated refresh token should survive a restart")
+	}
+	// 凭证更新后不再使用保存的refresh token
+	reloaded.AddAccount(OpenaiAccount{Email: "a@example.com", Password: "new-pass"})
+	if reloaded.accounts["a@example.com"].RefreshToken != "" {
+		t.Error("saved refresh token should be dropped when credentials change")
+	}
+}
+
+func TestSchedulerBackoff(t *testing.T) {
+	scheduler, _ := NewScheduler(&FakeOpenTokens{}, nil, SchedulerOptions{UniqueName: "fireinrain", MaxBackoff: 5 * time.Minute})
+	if d := scheduler.backoff(3); d != 4*time.Minute {
+		t.Errorf("unexpected backoff: %v", d)
+	}
+	if d := scheduler.backoff(10); d != 5*time.Minute {
+		t.Errorf("unexpected max backoff: %v", d)
+	}
+}
+
+func TestSchedulerRevokedRefreshToken(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	scheduler, _ := NewScheduler(&FakeOpenTokens{}, nil, SchedulerOptions{UniqueName: "fireinrain", Jitter: -1, Spacing: time.Millisecond})
+	scheduler.now = func() time.Time { return now }
+	scheduler.AddAccount(OpenaiAccount{Email: "a@example.com", RefreshToken: "rt-a"})
+	scheduler.AddAccount(OpenaiAccount{Email: "b@example.com", RefreshToken: "rt-b", Password: "pass"})
+	revoked := &OAuthError{Code: "invalid_grant", Status: 403}
+	scheduler.renew = func(account OpenaiAccount) (renewal, error) {
+		return renewal{}, fmt.Errorf("refresh token failed: %w", revoked)
+	}
+	if err := scheduler.RunOnce(context.Background()); err != nil {
+		t.Fatalf("run once: %v", err)
+	}
+	a, b := scheduler.entries["a@example.com"], scheduler.entries["b@example.com"]
+	if !a.RefreshTokenRevoked || !a.Disabled {
+		t.Errorf("account without other credential should be disabled: %+v", a)
+	}
+	if !b.RefreshTokenRevoked || b.Disabled || b.NextRunAt != now.Unix() {
+		t.Errorf("account with password should retry with password: %+v", b)
+	}
+	if scheduler.accounts["b@example.com"].RefreshToken != "" {
+		t.Error("revoked refresh token should be dropped")
+	}
+
+	scheduler.AddAccount(OpenaiAccount{Email: "a@example.com", RefreshToken: "rt-new"})
+	if a.Disabled || a.NextRunAt != now.Unix() {
+		t.Errorf("account with new refresh token should be enabled: %+v", a)
+	}
+}
+
+func TestSchedulerRevivesDisabledAfterRestart(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	opts := SchedulerOptions{UniqueName: "fireinrain", Jitter: -1, Spacing: time.Millisecond, StatePath: filepath.Join(t.TempDir(), "schedule.json")}
+	tokens := NewFakeOpenTokens(WithClock(&fakeClock{now: now}))
+	scheduler, _ := NewScheduler(tokens, []OpenaiAccount{{Email: "a@example.com", RefreshToken: "rt-a"}}, opts)
+	scheduler.renew = func(account OpenaiAccount) (renewal, error) {
+		return renewal{}, &OAuthError{Code: "invalid_grant", Status: 403}
+	}
+	if err := scheduler.RunOnce(context.Background()); err != nil {
+		t.Fatalf("run once: %v", err)
+	}
+
+	same, _ := NewScheduler(tokens, []OpenaiAccount{{Email: "a@example.com", RefreshToken: "rt-a"}}, opts)
+	if entry := same.entries["a@example.com"]; !entry.Disabled {
+		t.Errorf("unchanged credentials should stay disabled: %+v", entry)
+	}
+	revived, _ := NewScheduler(tokens, []OpenaiAccount{{Email: "a@example.com", RefreshToken: "rt-new"}}, opts)
+	if entry := revived.entries["a@example.com"]; entry.Disabled || entry.RefreshTokenRevoked {
+		t.Errorf("new credentials should enable the account after restart: %+v", entry)
+	}
+}
+
+func TestSchedulerNextWaitSkipsDisabled(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	scheduler, _ := NewScheduler(&FakeOpenTokens{}, nil, SchedulerOptions{UniqueName: "fireinrain", TickInterval: time.Hour})
+	scheduler.now = func() time.Time { return now }
+	scheduler.AddAccount(OpenaiAccount{Email: "a@example.com", RefreshToken: "rt-a"})
+	scheduler.AddAccount(OpenaiAccount{Email: "b@example.com", RefreshToken: "rt-b"})
+	scheduler.entries["a@example.com"].Disabled = true
+	scheduler.entries["a@example.com"].NextRunAt = now.Add(-time.Hour).Unix()
+	scheduler.entries["b@example.com"].NextRunAt = now.Add(10 * time.Minute).Unix()
+	if wait := scheduler.nextWait(); wait != 10*time.Minute {
+		t.Errorf("wait = %v, want 10m", wait)
+	}
+}
+
+func TestSchedulerUsesInjectedOptions(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	platform := &fakePlatform{}
+	clock := &fakeClock{now: now}
+	tokens := NewFakeOpenTokens(WithPlatform(platform), WithClock(clock), WithLogger(&bufferLogger{}))
+	accounts := []OpenaiAccount{
+		{Email: "a@example.com", SessionToken: "a"},
+		{Email: "b@example.com", SessionToken: "b"},
+	}
+	scheduler, err := NewScheduler(tokens, accounts, SchedulerOptions{UniqueName: "fireinrain", Jitter: -1, Spacing: 15 * time.Second})
+	if err != nil {
+		t.Fatalf("new scheduler: %v", err)
+	}
+	if err := scheduler.RunOnce(context.Background()); err != nil {
+		t.Fatalf("run once: %v", err)
+	}
+	if len(platform.shareRequests) != 2 || platform.shareRequests[0].AccessToken != "at-a" {
+		t.Errorf("renewal should use the injected platform: %+v", platform.shareRequests)
+	}
+	if clock.slept != 15*time.Second {
+		t.Errorf("spacing should go through the clock: %v", clock.slept)
+	}
+}
+
+func TestSchedulerUsesRotatedRefreshToken(t *testing.T) {
+	server, used := refreshServer(t)
+	platform := &fakePlatform{}
+	tokens := NewFakeOpenTokens(WithPlatform(platform), WithClock(&fakeClock{now: time.Unix(1700000000, 0)}), WithLogger(&bufferLogger{}),
+		WithHTTPClient(&http.Client{Transport: rewriteTransport{target: server.URL}}))
+	scheduler, err := NewScheduler(tokens, []OpenaiAccount{{Email: "a@example.com", RefreshToken: "rt-0"}}, SchedulerOptions{UniqueName: "fireinrain", Jitter: -1})
+	if err != nil {
+		t.Fatalf("new scheduler: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := scheduler.RunOnce(context.Background()); err != nil {
+			t.Fatalf("run once: %v", err)
+		}
+	}
+	if strings.Join(*used, ",") != "rt-0,rt-1" {
+		t.Errorf("next run should use the rotated refresh token: %v", *used)
+	}
+	if entry := scheduler.entries["a@example.com"]; entry.Failures != 0 || entry.Disabled {
+		t.Errorf("account should stay healthy: %+v", entry)
+	}
+}
+
+func TestSchedulerAlertsExpiringTokens(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var events []notify.Event
+	tokens := NewFakeOpenTokens(WithLogger(&bufferLogger{}))
+	tokens.Notifier = notify.NotifierFunc(func(ctx context.Context, event notify.Event) error {
+		events = append(events, event)
+		return nil
+	})
+	opts := SchedulerOptions{UniqueName: "fireinrain", LeadTime: time.Hour, ExpiryAlert: 48 * time.Hour, Jitter: -1, Spacing: time.Millisecond}
+	scheduler, _ := NewScheduler(tokens, nil, opts)
+	scheduler.now = func() time.Time { return now }
+	scheduler.AddAccount(OpenaiAccount{Email: "a@example.com", Password: "pass"})
+	scheduler.AddAccount(OpenaiAccount{Email: "b@example.com", Password: "pass"})
+	scheduler.entries["b@example.com"].ShareTokenExpireAt = now.Add(24 * time.Hour).Unix()
+	scheduler.renew = func(account OpenaiAccount) (renewal, error) {
+		if account.Email == "b@example.com" {
+			return renewal{}, errors.New("login failed")
+		}
+		// 续期成功但share token 仍在提醒窗口内
+		return renewal{shareToken: fakeopen.SharedToken{TokenKey: "fk-a", ExpireAt: now.Add(36 * time.Hour).Unix()}}, nil
+	}
+	if err := scheduler.RunOnce(context.Background()); err != nil {
+		t.Fatalf("run once: %v", err)
+	}
+	var expiring []notify.Event
+	for _, event := range events {
+		if event.Type == notify.EventTokenExpiring {
+			expiring = append(expiring, event)
+		}
+	}
+	if len(expiring) != 2 {
+		t.Fatalf("expected 2 expiring events, got %+v", events)
+	}
+	if expiring[0].Account != "a@example.com" || expiring[0].Message != "token is about to expire" {
+		t.Errorf("renewed token inside the window should be alerted: %+v", expiring[0])
+	}
+	if expiring[1].Account != "b@example.com" || expiring[1].Message != "login failed" {
+		t.Errorf("failed renewal should carry the error: %+v", expiring[1])
+	}
+
+	// 同一过期时间不重复通知
+	events = nil
+	now = now.Add(2 * time.Hour)
+	scheduler.renew = func(account OpenaiAccount) (renewal, error) {
+		return renewal{}, errors.New("login failed")
+	}
+	if err := scheduler.RunOnce(context.Background()); err != nil {
+		t.Fatalf("run once: %v", err)
+	}
+	for _, event := range events {
+		if event.Type == notify.EventTokenExpiring {
+			t.Errorf("duplicate expiring event: %+v", event)
+		}
+	}
+}
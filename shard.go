@@ -0,0 +1,204 @@
+package opaitokens
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ShardOptions 多pool分片选项
+type ShardOptions struct {
+	//每个分片最多成员数 为0时使用PooledTokenAccountsLimit
+	Size int
+	//上一次构建的manifest 设置后账号尽量保持在原来的分片中, 并沿用各分片的pk
+	Previous *ShardManifest
+}
+
+// PoolShard 单个分片
+type PoolShard struct {
+	Index     int    `json:"index"`
+	PoolToken string `json:"pool_token"`
+	//分配到该分片的所有账号, 包括本次获取share token 失败的账号, 重建时按此保持分片不变
+	Assigned []string `json:"assigned,omitempty"`
+	//成功加入pool 的成员
+	Members  []string         `json:"members"`
+	Excluded []ExcludedMember `json:"excluded,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// ShardManifest 分片与成员的对应关系
+type ShardManifest struct {
+	Shards []PoolShard `json:"shards"`
+	//上一次manifest 中已没有成员的分片的pk, 不会再更新, 调用方可以撤销
+	Dropped []string `json:"dropped,omitempty"`
+}
+
+// ShardOf
+//
+//	@Description: 查找成员所在的分片序号, 不存在返回-1
+//	@receiver m
+//	@param member
+//	@return int
+func (m *ShardManifest) ShardOf(member string) int {
+	for _, shard := range m.Shards {
+		for _, name := range shard.Members {
+			if name == member {
+				return shard.Index
+			}
+		}
+	}
+	return -1
+}
+
+// assignShards
+//
+//	@Description: 将成员分配到大小不超过size的分片中
+//	设置previous时, 成员保留在原分片(按Assigned, 旧的manifest 没有Assigned 时按Members), 新成员依次填充有空位的分片
+//	@param members
+//	@param size
+//	@param previous
+//	@return [][]string
+func assignShards(members []string, size int, previous *ShardManifest) [][]string {
+	var shards [][]string
+	assigned := make(map[string]bool)
+	if previous != nil {
+		wanted := make(map[string]bool)
+		for _, member := range members {
+			wanted[member] = true
+		}
+		for _, shard := range previous.Shards {
+			for len(shards) <= shard.Index {
+				shards = append(shards, nil)
+			}
+			assignedMembers := shard.Assigned
+			if len(assignedMembers) == 0 {
+				assignedMembers = shard.Members
+			}
+			for _, member := range assignedMembers {
+				if wanted[member] && !assigned[member] && len(shards[shard.Index]) < size {
+					shards[shard.Index] = append(shards[shard.Index], member)
+					assigned[member] = true
+				}
+			}
+		}
+	}
+	index := 0
+	for _, member := range members {
+		if assigned[member] {
+			continue
+		}
+		for index < len(shards) && len(shards[index]) >= size {
+			index++
+		}
+		if index == len(shards) {
+			shards = append(shards, nil)
+		}
+		shards[index] = append(shards[index], member)
+		assigned[member] = true
+	}
+	return shards
+}
+
+// FetchShardedPooledTokens
+//
+//	@Description: 账号超过pool上限时, 按分片注册多个pool token
+//	@receiver receiver
+//	@param openaiAccounts
+//	@param uniqueName
+//	@param opts
+//	@return ShardManifest
+//	@return error
+func (receiver *FakeOpenTokens) FetchShardedPooledTokens(openaiAccounts []OpenaiAccount, uniqueName string, opts ShardOptions) (ShardManifest, error) {
+	candidates, skKeys := receiver.sourceCandidates(AccountSources(openaiAccounts), uniqueName)
+	return receiver.buildShardedPools(candidates, skKeys, opts)
+}
+
+// FetchShardedPooledTokensWithRefreshToken
+//
+//	@Description: 使用refresh token 按分片注册多个pool token
+//	@receiver receiver
+//	@param renewSharedTokenRFTs
+//	@param uniqueName
+//	@param opts
+//	@return ShardManifest
+//	@return error
+func (receiver *FakeOpenTokens) FetchShardedPooledTokensWithRefreshToken(renewSharedTokenRFTs []RenewSharedTokenRFT, uniqueName string, opts ShardOptions) (ShardManifest, error) {
+	candidates, skKeys := receiver.sourceCandidates(RefreshTokenSources(renewSharedTokenRFTs), uniqueName)
+	return receiver.buildShardedPools(candidates, skKeys, opts)
+}
+
+// buildShardedPools
+//
+//	@Description: 按分片注册pool token, sk key 没有稳定的账号标识, 无法保持分片不变, 因此不支持
+//	@receiver receiver
+//	@param candidates
+//	@param skKeys
+//	@param opts
+//	@return ShardManifest
+//	@return error
+func (receiver *FakeOpenTokens) buildShardedPools(candidates []poolCandidate, skKeys []string, opts ShardOptions) (ShardManifest, error) {
+	manifest := ShardManifest{}
+	size := opts.Size
+	if size == 0 {
+		size = PooledTokenAccountsLimit
+	}
+	if size < 0 || size > PooledTokenAccountsLimit {
+		return manifest, fmt.Errorf("invalid shard size %d, it must be between 1 and %d", size, PooledTokenAccountsLimit)
+	}
+	if len(skKeys) > 0 {
+		return manifest, errors.New("sk keys are not supported in sharded pools, use BuildPool instead")
+	}
+	if len(candidates) == 0 {
+		return manifest, errors.New("invalid openai account list")
+	}
+
+	byMember := make(map[string]poolCandidate)
+	var members []string
+	for _, candidate := range candidates {
+		if _, ok := byMember[candidate.member]; ok {
+			continue
+		}
+		byMember[candidate.member] = candidate
+		members = append(members, candidate.member)
+	}
+
+	var er error
+	for index, shardMembers := range assignShards(members, size, opts.Previous) {
+		//复用上一次该分片的pk
+		poolToken := ""
+		if opts.Previous != nil {
+			for _, previous := range opts.Previous.Shards {
+				if previous.Index == index {
+					poolToken = previous.PoolToken
+				}
+			}
+		}
+		if len(shardMembers) == 0 {
+			if poolToken != "" {
+				receiver.logf("pool shard %v has no members left, dropping %v.", index, maskKey(poolToken))
+				manifest.Dropped = append(manifest.Dropped, poolToken)
+			}
+			continue
+		}
+		var shardCandidates []poolCandidate
+		for _, member := range shardMembers {
+			shardCandidates = append(shardCandidates, byMember[member])
+		}
+		receiver.logf("building pool shard %v with %v members.", index, len(shardMembers))
+		result, err := receiver.buildPool(shardCandidates, nil, poolToken)
+		shard := PoolShard{
+			Index:     index,
+			PoolToken: result.PoolToken,
+			Assigned:  shardMembers,
+			Members:   result.Members,
+			Excluded:  result.Excluded,
+		}
+		if err != nil {
+			receiver.logf("error building pool shard %v: %v", index, err)
+			shard.PoolToken = poolToken
+			shard.Error = err.Error()
+			er = err
+		}
+		manifest.Shards = append(manifest.Shards, shard)
+	}
+	return manifest, er
+}
@@ -0,0 +1,75 @@
+package opaitokens
+
+import (
+	"fmt"
+	"github.com/fireinrain/opaitokens/fakeopen"
+	"strings"
+	"testing"
+)
+
+func TestAssignShards(t *testing.T) {
+	var members []string
+	for i := 0; i < 250; i++ {
+		members = append(members, fmt.Sprintf("user%d@example.com", i))
+	}
+	shards := assignShards(members, PooledTokenAccountsLimit, nil)
+	if len(shards) != 3 || len(shards[0]) != 100 || len(shards[2]) != 50 {
+		sizes := make([]int, 0, len(shards))
+		for _, shard := range shards {
+			sizes = append(sizes, len(shard))
+		}
+		t.Fatalf("unexpected shard sizes: %v", sizes)
+	}
+}
+
+func TestAssignShardsStable(t *testing.T) {
+	previous := &ShardManifest{Shards: []PoolShard{
+		{Index: 0, Members: []string{"a", "b"}},
+		{Index: 1, Members: []string{"c", "d"}},
+	}}
+	// a 被移除, e 是新成员
+	shards := assignShards([]string{"e", "d", "c", "b"}, 2, previous)
+	if len(shards) != 2 {
+		t.Fatalf("expected 2 shards, got %v", shards)
+	}
+	if shards[0][0] != "b" || shards[0][1] != "e" {
+		t.Errorf("unexpected shard 0: %v", shards[0])
+	}
+	if shards[1][0] != "c" || shards[1][1] != "d" {
+		t.Errorf("unexpected shard 1: %v", shards[1])
+	}
+}
+
+func TestBuildShardedPoolsReportsDroppedShards(t *testing.T) {
+	platform := &fakePlatform{}
+	tokens := NewFakeOpenTokens(WithPlatform(platform), WithClock(&fakeClock{}), WithLogger(&bufferLogger{}))
+	previous := &ShardManifest{Shards: []PoolShard{
+		{Index: 0, PoolToken: "pk-fake", Members: []string{"a"}},
+		{Index: 1, PoolToken: "pk-1", Members: []string{"b"}},
+	}}
+	candidates := []poolCandidate{{member: "a", fetch: func() (SharedTokenResult, error) {
+		return SharedTokenResult{SharedToken: fakeopen.SharedToken{TokenKey: "fk-a"}}, nil
+	}}}
+	manifest, err := tokens.buildShardedPools(candidates, nil, ShardOptions{Size: 1, Previous: previous})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Shards) != 1 || len(manifest.Dropped) != 1 || manifest.Dropped[0] != "pk-1" {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+	if _, err := tokens.buildShardedPools(candidates, []string{"sk-xxxx"}, ShardOptions{}); err == nil {
+		t.Error("sk keys should be rejected")
+	}
+}
+
+func TestAssignShardsKeepsFailedMembers(t *testing.T) {
+	// b 上一次获取share token 失败, 不在Members 中, 但仍属于分片0
+	previous := &ShardManifest{Shards: []PoolShard{
+		{Index: 0, Assigned: []string{"a", "b"}, Members: []string{"a"}},
+		{Index: 1, Assigned: []string{"c"}, Members: []string{"c"}},
+	}}
+	shards := assignShards([]string{"e", "c", "b", "a"}, 2, previous)
+	if len(shards) != 2 || strings.Join(shards[0], ",") != "a,b" || strings.Join(shards[1], ",") != "c,e" {
+		t.Fatalf("failed member should keep its shard: %v", shards)
+	}
+}
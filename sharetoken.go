@@ -0,0 +1,104 @@
+package opaitokens
+
+import (
+	"errors"
+	"github.com/fireinrain/opaitokens/fakeopen"
+	"github.com/fireinrain/opaitokens/model"
+	"net/url"
+	"strings"
+)
+
+// ShareTokenOptions 注册share token 的选项, 零值与fakeopen的默认值一致
+type ShareTokenOptions struct {
+	//有效期 单位秒 为0时使用access token 的过期时间
+	ExpiresIn int `json:"expires_in,omitempty"`
+	//限制只能在该站点使用 如 https://chat.example.com 为空表示不限制
+	SiteLimit string `json:"site_limit,omitempty"`
+	//隐藏对话历史 默认显示
+	HideConversations bool `json:"hide_conversations,omitempty"`
+	//显示账号信息 默认不显示
+	ShowUserinfo bool `json:"show_userinfo,omitempty"`
+}
+
+// Validate
+//
+//	@Description: 检查有效期和站点限制
+//	@receiver o
+//	@return error
+func (o ShareTokenOptions) Validate() error {
+	if o.ExpiresIn < 0 {
+		return errors.New("share token expires_in must not be negative")
+	}
+	if o.SiteLimit != "" {
+		u, err := url.Parse(o.SiteLimit)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return errors.New("invalid share token site limit: " + o.SiteLimit)
+		}
+	}
+	return nil
+}
+
+func (o ShareTokenOptions) request(uniqueName string, accessToken string) fakeopen.SharedTokenReq {
+	return fakeopen.SharedTokenReq{
+		UniqueName:        uniqueName,
+		AccessToken:       accessToken,
+		ExpiresIn:         o.ExpiresIn,
+		SiteLimit:         strings.TrimSuffix(o.SiteLimit, "/"),
+		ShowConversations: !o.HideConversations,
+		ShowUserinfo:      o.ShowUserinfo,
+	}
+}
+
+// shareTokenOptionsSource 自带share token 选项的凭证
+type shareTokenOptionsSource interface {
+	shareTokenOptions() (ShareTokenOptions, bool)
+}
+
+func (a OpenaiAccount) shareTokenOptions() (ShareTokenOptions, bool) {
+	if a.ShareTokenOptions == nil {
+		return ShareTokenOptions{}, false
+	}
+	return *a.ShareTokenOptions, true
+}
+
+// optionsSource 为任意凭证指定share token 选项
+type optionsSource struct {
+	CredentialSource
+	opts ShareTokenOptions
+}
+
+func (s optionsSource) tokenWith(cfg *config) (model.OpenaiToken, error) {
+	if c, ok := s.CredentialSource.(configuredSource); ok {
+		return c.tokenWith(cfg)
+	}
+	return s.CredentialSource.Token()
+}
+
+func (s optionsSource) shareTokenOptions() (ShareTokenOptions, bool) {
+	return s.opts, true
+}
+
+// WithShareTokenOptions
+//
+//	@Description: 为单个凭证指定share token 选项, 覆盖FakeOpenTokens.ShareTokenOptions
+//	@param src
+//	@param opts
+//	@return CredentialSource
+func WithShareTokenOptions(src CredentialSource, opts ShareTokenOptions) CredentialSource {
+	return optionsSource{CredentialSource: src, opts: opts}
+}
+
+// shareTokenOptionsFor
+//
+//	@Description: 凭证自带选项时使用凭证的选项, 否则使用默认选项
+//	@receiver receiver
+//	@param src
+//	@return ShareTokenOptions
+func (receiver *FakeOpenTokens) shareTokenOptionsFor(src CredentialSource) ShareTokenOptions {
+	if s, ok := src.(shareTokenOptionsSource); ok {
+		if opts, ok := s.shareTokenOptions(); ok {
+			return opts
+		}
+	}
+	return receiver.ShareTokenOptions
+}
@@ -0,0 +1,45 @@
+package opaitokens
+
+import (
+	"testing"
+)
+
+func TestShareTokenOptions(t *testing.T) {
+	req := ShareTokenOptions{}.request("fireinrain", "eyJ")
+	if req.ExpiresIn != 0 || req.SiteLimit != "" || !req.ShowConversations || req.ShowUserinfo {
+		t.Errorf("zero options should keep fakeopen defaults: %+v", req)
+	}
+	opts := ShareTokenOptions{ExpiresIn: 3600, SiteLimit: "https://chat.example.com/", HideConversations: true, ShowUserinfo: true}
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	req = opts.request("fireinrain", "eyJ")
+	if req.ExpiresIn != 3600 || req.SiteLimit != "https://chat.example.com" || req.ShowConversations || !req.ShowUserinfo {
+		t.Errorf("unexpected request: %+v", req)
+	}
+	if err := (ShareTokenOptions{ExpiresIn: -1}).Validate(); err == nil {
+		t.Error("negative expires_in should fail")
+	}
+	if err := (ShareTokenOptions{SiteLimit: "chat.example.com"}).Validate(); err == nil {
+		t.Error("site limit without scheme should fail")
+	}
+}
+
+func TestShareTokenOptionsFor(t *testing.T) {
+	receiver := &FakeOpenTokens{ShareTokenOptions: ShareTokenOptions{ExpiresIn: 86400}}
+	intern := OpenaiAccount{Email: "intern@example.com", Password: "p", ShareTokenOptions: &ShareTokenOptions{ExpiresIn: 3600}}
+	core := OpenaiAccount{Email: "core@example.com", Password: "p"}
+	if opts := receiver.shareTokenOptionsFor(intern); opts.ExpiresIn != 3600 {
+		t.Errorf("account override expected: %+v", opts)
+	}
+	if opts := receiver.shareTokenOptionsFor(core); opts.ExpiresIn != 86400 {
+		t.Errorf("default options expected: %+v", opts)
+	}
+	src := WithShareTokenOptions(RefreshTokenSource{Email: "a@example.com", RefreshToken: "rt"}, ShareTokenOptions{SiteLimit: "https://a.example.com"})
+	if opts := receiver.shareTokenOptionsFor(src); opts.SiteLimit != "https://a.example.com" {
+		t.Errorf("source override expected: %+v", opts)
+	}
+	if src.Name() != "a@example.com" {
+		t.Errorf("unexpected name: %s", src.Name())
+	}
+}
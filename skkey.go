@@ -0,0 +1,57 @@
+package opaitokens
+
+import (
+	"context"
+	"github.com/fireinrain/opaitokens/health"
+	"time"
+)
+
+// ValidateSkKeys
+//
+//	@Description: 检查sk key格式并去重, 设置SkKeyChecker时请求模型列表确认key可用
+//	已过期或被撤销的key会被排除, 检查失败(网络错误等)的key保留
+//	@receiver receiver
+//	@param skKeys
+//	@return []string 可用的key
+//	@return []ExcludedMember 被排除的key及原因
+func (receiver *FakeOpenTokens) ValidateSkKeys(skKeys []string) ([]string, []ExcludedMember) {
+	var valid []string
+	var excluded []ExcludedMember
+	seen := make(map[string]bool)
+	for _, key := range skKeys {
+		if !skKeyPattern.MatchString(key) {
+			excluded = append(excluded, ExcludedMember{Member: maskKey(key), Reason: "invalid sk key format"})
+			continue
+		}
+		if seen[key] {
+			excluded = append(excluded, ExcludedMember{Member: maskKey(key), Reason: "duplicate sk key"})
+			continue
+		}
+		seen[key] = true
+		valid = append(valid, key)
+	}
+	if receiver.SkKeyChecker == nil || len(valid) == 0 {
+		return valid, excluded
+	}
+
+	var targets []health.Target
+	for _, key := range valid {
+		targets = append(targets, health.Target{Name: maskKey(key), Kind: health.KindAPIKey, Token: key})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	report := receiver.SkKeyChecker.CheckAll(ctx, targets)
+	valid = nil
+	for _, result := range report.Results {
+		switch result.Status {
+		case health.StatusExpired, health.StatusRevoked:
+			excluded = append(excluded, ExcludedMember{Member: result.Target.Name, Reason: "sk key " + string(result.Status) + ": " + result.Detail})
+		case health.StatusUnknown:
+			receiver.logf("check sk key %v failed, keep it: %v", result.Target.Name, result.Detail)
+			valid = append(valid, result.Target.Token)
+		default:
+			valid = append(valid, result.Target.Token)
+		}
+	}
+	return valid, excluded
+}
@@ -0,0 +1,39 @@
+package opaitokens
+
+import (
+	"github.com/fireinrain/opaitokens/health"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateSkKeys(t *testing.T) {
+	goodKey := "sk-good0000000000000000000000000000000000000000000"
+	revokedKey := "sk-revoked000000000000000000000000000000000000000"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer "+goodKey {
+			w.Write([]byte(`{"data": []}`))
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": {"code": "invalid_api_key"}}`))
+	}))
+	defer server.Close()
+
+	receiver := &FakeOpenTokens{}
+	valid, excluded := receiver.ValidateSkKeys([]string{goodKey, "sk-typo", goodKey, revokedKey})
+	if len(valid) != 2 || len(excluded) != 2 {
+		t.Fatalf("unexpected format check result: %v %v", valid, excluded)
+	}
+
+	checker := health.NewChecker(server.URL)
+	checker.Endpoints[health.KindAPIKey] = server.URL
+	receiver.SkKeyChecker = checker
+	valid, excluded = receiver.ValidateSkKeys([]string{goodKey, revokedKey})
+	if len(valid) != 1 || valid[0] != goodKey {
+		t.Errorf("unexpected valid keys: %v", valid)
+	}
+	if len(excluded) != 1 || excluded[0].Member != maskKey(revokedKey) {
+		t.Errorf("unexpected excluded keys: %v", excluded)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/fireinrain/opaitokens/gateway"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 自建的fk/pk 签发服务, 使用方式:
+//
+//	GATEWAY_ADMIN_TOKEN=xxx go run ./src/gateway -addr :8181 -state gateway.json
+//
+// 然后使用fakeopen.NewAiFakeOpenPlatformWithBaseURL("http://127.0.0.1:8181") 签发fk/pk,
+// platform 的client 需要带上 Authorization: Bearer <admin token>,
+// 使用 Authorization: Bearer pk-xxx 请求 http://127.0.0.1:8181/v1/*
+func main() {
+	addr := flag.String("addr", ":8181", "listen address")
+	statePath := flag.String("state", "gateway.json", "file to persist share tokens and pool tokens, empty for memory only")
+	upstream := flag.String("upstream", gateway.DefaultUpstreamURL, "upstream base url for /v1/* requests")
+	strategyName := flag.String("strategy", "roundrobin", "member selection strategy: roundrobin, random, lru, weighted (see -weights); add -sticky to pin conversations")
+	weights := flag.String("weights", "plus=5,free=1,api=3", "plan weights for -strategy weighted, e.g. plus=5,free=1,api=3")
+	defaultWeight := flag.Int("default-weight", 1, "weight for plans missing from -weights, <=0 tries them last")
+	sticky := flag.Bool("sticky", false, "keep requests with the same "+gateway.DefaultStickyHeader+" on one member, on top of -strategy")
+	cooldown := flag.Duration("cooldown", time.Minute, "how long a failed member is tried last")
+	adminToken := flag.String("admin-token", os.Getenv("GATEWAY_ADMIN_TOKEN"), "bearer token required by /token/register, /pool/update and /pool/info, defaults to $GATEWAY_ADMIN_TOKEN")
+	verifyURL := flag.String("verify-url", gateway.DefaultVerifyURL, "upstream url used to verify access tokens before issuing share tokens")
+	flag.Parse()
+
+	if *adminToken == "" {
+		log.Fatal("admin token is required, set -admin-token or GATEWAY_ADMIN_TOKEN")
+	}
+	g, err := gateway.New(gateway.Options{
+		StatePath:  *statePath,
+		Verify:     gateway.UpstreamVerifier(nil, *verifyURL),
+		AdminToken: *adminToken,
+	})
+	if err != nil {
+		log.Fatalf("open gateway failed: %v", err)
+	}
+	log.Printf("gateway listening on %s", *addr)
+	mux := http.NewServeMux()
+	mux.Handle("/", g.Handler())
+	var strategy gateway.Strategy
+	switch *strategyName {
+	case "roundrobin":
+		strategy = gateway.RoundRobin()
+	case "random":
+		strategy = gateway.Random()
+	case "lru":
+		strategy = gateway.LeastRecentlyUsed()
+	case "weighted":
+		planWeights, err := parseWeights(*weights)
+		if err != nil {
+			log.Fatalf("invalid -weights: %v", err)
+		}
+		strategy = gateway.Weighted(planWeights, *defaultWeight)
+	default:
+		log.Fatalf("unknown strategy %q", *strategyName)
+	}
+	if *sticky {
+		strategy = gateway.Sticky(strategy)
+	}
+	mux.Handle("/v1/", g.Proxy(gateway.ProxyOptions{
+		UpstreamURL: *upstream,
+		Balancer:    gateway.NewBalancer(strategy, *cooldown),
+	}))
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+// parseWeights 解析 plus=5,free=1 形式的权重
+func parseWeights(value string) (map[string]int, error) {
+	weights := make(map[string]int)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		plan, weight, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q should be plan=weight", item)
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(weight))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", item, err)
+		}
+		weights[strings.TrimSpace(plan)] = w
+	}
+	return weights, nil
+}
@@ -1,20 +1,24 @@
 package main
-import "fmt"
-import "opaitokens"
-func main(){
-    email := ""
+
+import (
+	"fmt"
+	"github.com/fireinrain/opaitokens"
+)
+
+func main() {
+	email := ""
 	password := ""
 
-	tokens := opaitokens.NewOpaiTokens(email, password)
+	tokens := opaitokens.NewOpaiTokens(email, password, false)
 	token := tokens.FetchToken()
 	fmt.Printf("token info: %v\n", token)
 	accessToken := token.OpenaiToken.AccessToken
 	// use the access token
-	fmt.Println("i am using access token: %v\n", accessToken)
+	fmt.Printf("i am using access token: %v\n", accessToken)
 
 	token = tokens.RefreshToken()
-	fmt.Println("token info again: %v\n", token)
+	fmt.Printf("token info again: %v\n", token)
 	accessToken = token.RefreshedToken.AccessToken
 	//use the refresh token
-	fmt.Println("i am using refresh token: %",accessToken)
+	fmt.Printf("i am using refresh token: %v\n", accessToken)
 }
@@ -4,6 +4,11 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 // GenerateCodeVerifier
@@ -29,3 +34,74 @@ func GenerateCodeChallenge(codeVerifier string) string {
 	codeChallenge := base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(sha256Hash[:])
 	return codeChallenge
 }
+
+// JwtClaims
+//
+//	@Description: 将jwt的payload解析到claims, 不校验签名
+//	@param token
+//	@param claims
+//	@return error
+func JwtClaims(token string, claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("invalid jwt format")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return errors.New("decode jwt payload failed: " + err.Error())
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return errors.New("unmarshal jwt payload failed: " + err.Error())
+	}
+	return nil
+}
+
+// JwtExpireAt
+//
+//	@Description: 解析jwt(如openai access token)中的exp, 不校验签名
+//	@param token
+//	@return int64 unix秒
+//	@return error
+func JwtExpireAt(token string) (int64, error) {
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := JwtClaims(token, &claims); err != nil {
+		return 0, err
+	}
+	if claims.Exp == 0 {
+		return 0, errors.New("jwt has no exp claim")
+	}
+	return claims.Exp, nil
+}
+
+// WriteFileAtomic
+//
+//	@Description: 先写入同目录下的临时文件再rename, 避免写入中断导致文件损坏
+//	@param path
+//	@param data
+//	@param perm
+//	@return error
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"encoding/base64"
 	"fmt"
 	"testing"
 )
@@ -15,3 +16,14 @@ func TestGenerateCodeChallenge(t *testing.T) {
 	challenge := GenerateCodeChallenge(verifier)
 	fmt.Println(challenge)
 }
+
+func TestJwtExpireAt(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"exp":1700000000}`))
+	exp, err := JwtExpireAt("eyJhbGciOiJSUzI1NiJ9." + payload + ".sig")
+	if err != nil || exp != 1700000000 {
+		t.Errorf("unexpected exp %d: %v", exp, err)
+	}
+	if _, err := JwtExpireAt("not-a-jwt"); err == nil {
+		t.Error("expected error for invalid jwt")
+	}
+}
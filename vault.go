@@ -0,0 +1,158 @@
+package opaitokens
+
+import (
+	"errors"
+	"github.com/fireinrain/opaitokens/vault"
+)
+
+// AccountsFromVault
+//
+//	@Description: 将凭证库中的账号转换为批量接口使用的OpenaiAccount
+//	@param v
+//	@return []OpenaiAccount
+func AccountsFromVault(v *vault.Vault) []OpenaiAccount {
+	var accounts []OpenaiAccount
+	for _, c := range v.List() {
+		accounts = append(accounts, OpenaiAccount{
+			Email:        c.Email,
+			Password:     c.Password,
+			MFA:          c.MFA,
+			RefreshToken: c.RefreshToken,
+			SessionToken: c.SessionToken,
+			Proxy:        c.Proxy,
+			Tags:         c.Tags,
+		})
+	}
+	return accounts
+}
+
+// ImportAccountsToVault
+//
+//	@Description: 将账号写入凭证库, 已存在的账号会保留已获取的token
+//	@param v
+//	@param accounts
+func ImportAccountsToVault(v *vault.Vault, accounts []OpenaiAccount) {
+	for _, account := range accounts {
+		c, _ := v.Get(account.Email)
+		c.Email = account.Email
+		c.Password = account.Password
+		c.MFA = account.MFA
+		c.RefreshToken = account.RefreshToken
+		c.SessionToken = account.SessionToken
+		c.Proxy = account.Proxy
+		c.Tags = account.Tags
+		v.Put(c)
+	}
+}
+
+// StoreTokensToVault
+//
+//	@Description: 将OpaiTokens获取到的token加密保存, 代替直接json序列化OpaiTokens
+//	@param v
+//	@param tokens
+func StoreTokensToVault(v *vault.Vault, tokens *OpaiTokens) {
+	c, _ := v.Get(tokens.Email)
+	c.Email = tokens.Email
+	c.Password = tokens.Password
+	c.MFA = tokens.MFA
+	if tokens.OpenaiToken.AccessToken != "" {
+		c.AccessToken = tokens.OpenaiToken.AccessToken
+	}
+	if tokens.RefreshedToken.AccessToken != "" {
+		c.AccessToken = tokens.RefreshedToken.AccessToken
+	}
+	if tokens.OpenaiToken.RefreshToken != "" {
+		c.RefreshToken = tokens.OpenaiToken.RefreshToken
+	}
+	if tokens.OpenaiToken.IDToken != "" {
+		c.IDToken = tokens.OpenaiToken.IDToken
+	}
+	v.Put(c)
+}
+
+// RenewSharedTokenFromVault
+//
+//	@Description: 使用凭证库中的账号刷新shared token
+//	@receiver receiver
+//	@param v
+//	@param uniqueName
+//	@return RenewResult
+//	@return error
+func (receiver *FakeOpenTokens) RenewSharedTokenFromVault(v *vault.Vault, uniqueName string) (RenewResult, error) {
+	accounts := AccountsFromVault(v)
+	if len(accounts) == 0 {
+		return RenewResult{}, errors.New("vault has no account")
+	}
+	return receiver.RenewSharedToken(accounts, uniqueName)
+}
+
+// FetchPooledTokenFromVault
+//
+//	@Description: 使用凭证库中的账号获取pooled token
+//	@receiver receiver
+//	@param v
+//	@param uniqueName
+//	@return PooledTokenResult
+//	@return error
+func (receiver *FakeOpenTokens) FetchPooledTokenFromVault(v *vault.Vault, uniqueName string) (PooledTokenResult, error) {
+	accounts := AccountsFromVault(v)
+	if len(accounts) == 0 {
+		return PooledTokenResult{}, errors.New("vault has no account")
+	}
+	return receiver.FetchPooledToken(accounts, uniqueName)
+}
+
+// StoreCredentialsToVault
+//
+//	@Description: 保存批量接口返回的refresh token 和id token, 之后从凭证库加载的账号会使用refresh token
+//	@param v
+//	@param credentials
+func StoreCredentialsToVault(v *vault.Vault, credentials []AccountCredential) {
+	for _, credential := range credentials {
+		c, ok := v.Get(credential.Email)
+		if !ok {
+			continue
+		}
+		if credential.RefreshToken != "" {
+			c.RefreshToken = credential.RefreshToken
+		}
+		if credential.IDToken != "" {
+			c.IDToken = credential.IDToken
+		}
+		v.Put(c)
+	}
+}
+
+// vaultStore 将获取到的token 保存到凭证库
+type vaultStore struct {
+	v *vault.Vault
+}
+
+// NewVaultStore
+//
+//	@Description: 使用凭证库作为WithStore 的store, 每次保存后写入文件
+//	@param v
+//	@return Store
+func NewVaultStore(v *vault.Vault) Store {
+	return vaultStore{v: v}
+}
+
+func (s vaultStore) LoadRefreshToken(email string) (string, bool) {
+	c, ok := s.v.Get(email)
+	return c.RefreshToken, ok && c.RefreshToken != ""
+}
+
+func (s vaultStore) SaveSharedToken(result SharedTokenResult) error {
+	c, _ := s.v.Get(result.Email)
+	c.Email = result.Email
+	c.ShareToken = result.TokenKey
+	c.ExpireAt = result.ExpireAt
+	if result.RefreshToken != "" {
+		c.RefreshToken = result.RefreshToken
+	}
+	if result.IDToken != "" {
+		c.IDToken = result.IDToken
+	}
+	s.v.Put(c)
+	return s.v.Save()
+}
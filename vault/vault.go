@@ -0,0 +1,392 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fireinrain/opaitokens/utils"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 加密存储账号密码, refresh token 等凭证
+// 文件整体使用AES-256-GCM加密, 密钥来自口令(scrypt派生)或者密钥文件
+
+const fileVersion = 1
+
+const (
+	kdfScrypt = "scrypt"
+	kdfNone   = "none"
+)
+
+// scrypt 参数
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+	keySize = 32
+)
+
+// 读取文件时scrypt 参数的上限, 防止构造的文件导致过大的内存和CPU消耗
+const (
+	maxScryptN      = 1 << 20
+	maxScryptR      = 32
+	maxScryptP      = 16
+	maxScryptMemory = 1 << 30
+)
+
+var ErrWrongKey = errors.New("vault: wrong key or corrupted data")
+
+// Credential 单个账号的凭证以及获取到的token
+type Credential struct {
+	Email        string   `json:"email"`
+	Password     string   `json:"password,omitempty"`
+	MFA          string   `json:"mfa,omitempty"`
+	RefreshToken string   `json:"refresh_token,omitempty"`
+	SessionToken string   `json:"session_token,omitempty"`
+	AccessToken  string   `json:"access_token,omitempty"`
+	IDToken      string   `json:"id_token,omitempty"`
+	ShareToken   string   `json:"share_token,omitempty"`
+	Proxy        string   `json:"proxy,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	//share token 过期时间 unix秒
+	ExpireAt  int64 `json:"expire_at,omitempty"`
+	UpdatedAt int64 `json:"updated_at,omitempty"`
+}
+
+// Key 用于加解密的密钥, 由口令或密钥文件得到
+type Key struct {
+	passphrase []byte
+	raw        []byte
+}
+
+// PassphraseKey
+//
+//	@Description: 使用口令, 每次保存时用随机salt通过scrypt派生密钥
+//	@param passphrase
+//	@return Key
+func PassphraseKey(passphrase string) Key {
+	return Key{passphrase: []byte(passphrase)}
+}
+
+// KeyFromFile
+//
+//	@Description: 读取密钥文件, 文件内容为32字节原始密钥或者其base64编码
+//	@param path
+//	@return Key
+//	@return error
+func KeyFromFile(path string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, errors.New("read key file failed: " + err.Error())
+	}
+	if len(data) == keySize {
+		return Key{raw: data}, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(decoded) != keySize {
+		return Key{}, fmt.Errorf("key file must contain %d raw bytes or their base64 encoding", keySize)
+	}
+	return Key{raw: decoded}, nil
+}
+
+// GenerateKeyFile
+//
+//	@Description: 生成随机密钥并以base64写入文件
+//	@param path
+//	@return Key
+//	@return error
+func GenerateKeyFile(path string) (Key, error) {
+	raw := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return Key{}, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(raw) + "\n"
+	if err := utils.WriteFileAtomic(path, []byte(encoded), 0600); err != nil {
+		return Key{}, err
+	}
+	return Key{raw: raw}, nil
+}
+
+func (k Key) valid() bool {
+	return len(k.raw) == keySize || len(k.passphrase) > 0
+}
+
+// envelope 加密后的文件格式
+type envelope struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Salt       string `json:"salt,omitempty"`
+	N          int    `json:"n,omitempty"`
+	R          int    `json:"r,omitempty"`
+	P          int    `json:"p,omitempty"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+func (k Key) derive(env *envelope) ([]byte, error) {
+	switch env.KDF {
+	case kdfNone:
+		if len(k.raw) != keySize {
+			return nil, errors.New("vault is encrypted with a key file")
+		}
+		return k.raw, nil
+	case kdfScrypt:
+		if len(k.passphrase) == 0 {
+			return nil, errors.New("vault is encrypted with a passphrase")
+		}
+		if err := checkScryptParams(env.N, env.R, env.P); err != nil {
+			return nil, err
+		}
+		salt, err := base64.StdEncoding.DecodeString(env.Salt)
+		if err != nil {
+			return nil, errors.New("invalid vault salt: " + err.Error())
+		}
+		return scrypt.Key(k.passphrase, salt, env.N, env.R, env.P, keySize)
+	}
+	return nil, errors.New("unsupported vault kdf: " + env.KDF)
+}
+
+// checkScryptParams N 必须是2的幂, scrypt 约需要128*N*R 字节内存
+func checkScryptParams(n, r, p int) error {
+	if n < 2 || n > maxScryptN || n&(n-1) != 0 {
+		return fmt.Errorf("invalid vault scrypt n %d", n)
+	}
+	if r < 1 || r > maxScryptR || p < 1 || p > maxScryptP {
+		return fmt.Errorf("invalid vault scrypt r %d or p %d", r, p)
+	}
+	if 128*int64(n)*int64(r) > maxScryptMemory {
+		return fmt.Errorf("vault scrypt parameters need too much memory (n %d, r %d)", n, r)
+	}
+	return nil
+}
+
+// Vault 加密凭证库
+type Vault struct {
+	path        string
+	key         Key
+	mu          sync.RWMutex
+	credentials map[string]Credential
+}
+
+// New
+//
+//	@Description: 创建一个空的凭证库, 调用Save后写入path
+//	@param path
+//	@param key
+//	@return *Vault
+func New(path string, key Key) *Vault {
+	return &Vault{
+		path:        path,
+		key:         key,
+		credentials: make(map[string]Credential),
+	}
+}
+
+// Open
+//
+//	@Description: 打开凭证库, 文件不存在时返回空库
+//	@param path
+//	@param key
+//	@return *Vault
+//	@return error
+func Open(path string, key Key) (*Vault, error) {
+	v := New(path, key)
+	err := v.Load()
+	if errors.Is(err, os.ErrNotExist) {
+		return v, nil
+	}
+	return v, err
+}
+
+// Load
+//
+//	@Description: 从文件读取并解密
+//	@receiver v
+//	@return error
+func (v *Vault) Load() error {
+	data, err := os.ReadFile(v.path)
+	if err != nil {
+		return err
+	}
+	env := &envelope{}
+	if err := json.Unmarshal(data, env); err != nil {
+		return errors.New("unmarshal vault failed: " + err.Error())
+	}
+	if env.Version != fileVersion {
+		return fmt.Errorf("unsupported vault version %d", env.Version)
+	}
+	key, err := v.key.derive(env)
+	if err != nil {
+		return err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(env.Nonce)
+	if err != nil {
+		return ErrWrongKey
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(env.Ciphertext)
+	if err != nil {
+		return ErrWrongKey
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return ErrWrongKey
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(env.KDF))
+	if err != nil {
+		return ErrWrongKey
+	}
+	var credentials []Credential
+	if err := json.Unmarshal(plaintext, &credentials); err != nil {
+		return errors.New("unmarshal vault credentials failed: " + err.Error())
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.credentials = make(map[string]Credential)
+	for _, c := range credentials {
+		v.credentials[normalize(c.Email)] = c
+	}
+	return nil
+}
+
+// Save
+//
+//	@Description: 加密后写入文件, 文件权限为0600
+//	@receiver v
+//	@return error
+func (v *Vault) Save() error {
+	if !v.key.valid() {
+		return errors.New("vault key is empty")
+	}
+	plaintext, err := json.Marshal(v.List())
+	if err != nil {
+		return errors.New("marshal vault credentials failed: " + err.Error())
+	}
+	env := &envelope{Version: fileVersion, KDF: kdfNone}
+	if len(v.key.raw) != keySize {
+		salt := make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return err
+		}
+		env.KDF = kdfScrypt
+		env.Salt = base64.StdEncoding.EncodeToString(salt)
+		env.N, env.R, env.P = scryptN, scryptR, scryptP
+	}
+	key, err := v.key.derive(env)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	env.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	env.Ciphertext = base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, []byte(env.KDF)))
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(v.path, data, 0600)
+}
+
+// Rotate
+//
+//	@Description: 使用新的密钥重新加密并保存
+//	@receiver v
+//	@param newKey
+//	@return error
+func (v *Vault) Rotate(newKey Key) error {
+	if !newKey.valid() {
+		return errors.New("new vault key is empty")
+	}
+	old := v.key
+	v.key = newKey
+	if err := v.Save(); err != nil {
+		v.key = old
+		return err
+	}
+	return nil
+}
+
+// Put
+//
+//	@Description: 添加或者替换账号凭证
+//	@receiver v
+//	@param credential
+func (v *Vault) Put(credential Credential) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	credential.UpdatedAt = time.Now().Unix()
+	v.credentials[normalize(credential.Email)] = credential
+}
+
+// Get
+//
+//	@Description: 按email查找凭证
+//	@receiver v
+//	@param email
+//	@return Credential
+//	@return bool
+func (v *Vault) Get(email string) (Credential, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	c, ok := v.credentials[normalize(email)]
+	return c, ok
+}
+
+// Delete
+//
+//	@Description: 删除账号凭证
+//	@receiver v
+//	@param email
+func (v *Vault) Delete(email string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.credentials, normalize(email))
+}
+
+// List
+//
+//	@Description: 按email排序返回所有凭证
+//	@receiver v
+//	@return []Credential
+func (v *Vault) List() []Credential {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	credentials := make([]Credential, 0, len(v.credentials))
+	for _, c := range v.credentials {
+		credentials = append(credentials, c)
+	}
+	sort.Slice(credentials, func(i, j int) bool {
+		return normalize(credentials[i].Email) < normalize(credentials[j].Email)
+	})
+	return credentials
+}
+
+func normalize(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
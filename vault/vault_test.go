@@ -0,0 +1,83 @@
+package vault
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVaultSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault.json")
+	v := New(path, PassphraseKey("correct horse"))
+	v.Put(Credential{Email: "a@example.com", Password: "secret-password", RefreshToken: "secret-refresh"})
+	if err := v.Save(); err != nil {
+		t.Fatalf("save vault: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "secret") {
+		t.Fatal("vault file contains plaintext secret")
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected file mode: %v", info.Mode())
+	}
+
+	loaded, err := Open(path, PassphraseKey("correct horse"))
+	if err != nil {
+		t.Fatalf("open vault: %v", err)
+	}
+	c, ok := loaded.Get("A@example.com")
+	if !ok || c.Password != "secret-password" || c.RefreshToken != "secret-refresh" {
+		t.Errorf("unexpected credential: %+v", c)
+	}
+	if _, err := Open(path, PassphraseKey("wrong")); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("expected wrong key error, got %v", err)
+	}
+}
+
+func TestVaultRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vault.json")
+	v := New(path, PassphraseKey("old"))
+	v.Put(Credential{Email: "a@example.com", Password: "pass"})
+	if err := v.Save(); err != nil {
+		t.Fatalf("save vault: %v", err)
+	}
+	key, err := GenerateKeyFile(filepath.Join(dir, "vault.key"))
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	if err := v.Rotate(key); err != nil {
+		t.Fatalf("rotate vault: %v", err)
+	}
+	if _, err := Open(path, PassphraseKey("old")); err == nil {
+		t.Error("old passphrase should not open rotated vault")
+	}
+	fileKey, err := KeyFromFile(filepath.Join(dir, "vault.key"))
+	if err != nil {
+		t.Fatalf("read key file: %v", err)
+	}
+	loaded, err := Open(path, fileKey)
+	if err != nil {
+		t.Fatalf("open rotated vault: %v", err)
+	}
+	if len(loaded.List()) != 1 {
+		t.Errorf("unexpected credentials: %v", loaded.List())
+	}
+}
+
+func TestVaultRejectsHostileScryptParams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault.json")
+	for _, params := range []string{`"n":1073741824,"r":8,"p":1`, `"n":1048576,"r":32,"p":1`, `"n":1000,"r":8,"p":1`, `"n":32768,"r":8,"p":0`} {
+		data := `{"version":1,"kdf":"scrypt","salt":"c2FsdA==",` + params + `,"nonce":"","ciphertext":""}`
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := Open(path, PassphraseKey("pass"))
+		if err == nil || !strings.Contains(err.Error(), "scrypt") {
+			t.Errorf("%s: expected scrypt parameter error, got %v", params, err)
+		}
+	}
+}